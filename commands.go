@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Maintenance and scripting commands, run instead of the TUI when slackbox is
+// given arguments, e.g. slackbox db compact
+const commandUsage = `Commands:
+  list [--format f]                 show the unread conversations
+  ack [--format f] <id|name>...     mark conversations as read
+  unack [--format f] <id|name>...   undo the latest ack of conversations
+  refresh [--format f]              fetch from slack, then show the unread conversations
+  open <id|name>                    open a conversation in slack
+  db compact                        trim old acknowledgements and shrink the db file
+  config check                      check the config file and show the settings in effect
+  token encrypt <path>              write a token to path encrypted with a passphrase,
+                                    for reading back with -tokenpath encrypted:<path>
+  login [--client-id id] [--port n] [source]
+                                    get a token by approving the slack app in the browser,
+                                    and store it in source (default the first tokenpath)
+
+Formats are text (the default), tsv and json.
+
+An id in more than one workspace needs its team, as TEAM/ID.
+
+login reads the app's client secret from $SLACKBOX_CLIENT_SECRET, and the app
+needs http://localhost:<port>/callback (port 8976 by default) as a redirect URL.`
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return fmt.Sprintf("%s\n\n%s", e.msg, commandUsage)
+}
+
+// What commands run against.
+type commandEnv struct {
+	// not connected for config, token and login commands
+	db *SlackBoxDB
+	// the settings in effect, from the config file and flags
+	settings settings
+	// connects to each workspace, for the commands that need slack
+	connectAPIs func() ([]SlackAPI, error)
+	refresh     refreshOptions
+	openURL     func(string) error
+	// reads tokens and passphrases without echoing them
+	readSecret secretReader
+	// where to log in, e.g. https://slack.com/
+	slackURL string
+	out      io.Writer
+}
+
+func runCommand(args []string, env *commandEnv) error {
+	switch args[0] {
+	case "list":
+		return runListCommand(args[1:], env)
+	case "ack":
+		return runAckCommand(args[1:], env, ackAction)
+	case "unack":
+		return runAckCommand(args[1:], env, unackAction)
+	case "refresh":
+		return runRefreshCommand(args[1:], env)
+	case "open":
+		return runOpenCommand(args[1:], env)
+	case "db":
+		return runDBCommand(args[1:], env)
+	case "config":
+		return runConfigCommand(args[1:], env)
+	case "token":
+		return runTokenCommand(args[1:], env)
+	case "login":
+		return runLoginCommand(args[1:], env)
+	default:
+		return &usageError{fmt.Sprintf("Unknown command %s", args[0])}
+	}
+}
+
+// Parse the command's flags, all of which are optional and come before its
+// other arguments, returning the output format and the other arguments.
+func parseCommandFlags(name string, args []string) (string, []string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	format := flags.String("format", "text", "")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return "", nil, &usageError{fmt.Sprintf("Bad arguments to %s: %s", name, err)}
+	}
+
+	switch *format {
+	case "text", "tsv", "json":
+		return *format, flags.Args(), nil
+	default:
+		return "", nil, &usageError{fmt.Sprintf("Unknown format %s", *format)}
+	}
+}
+
+// A conversation as the commands output it.
+type conversationRecord struct {
+	ID                    string `json:"id"`
+	Type                  string `json:"type"`
+	Name                  string `json:"name"`
+	LatestMsgTs           string `json:"latest_msg_ts"`
+	AcknowledgedThroughTs string `json:"acknowledged_through_ts"`
+	Unread                bool   `json:"unread"`
+	TeamID                string `json:"team_id"`
+}
+
+var tsvEscaper = strings.NewReplacer("\t", " ", "\n", " ")
+
+func writeConversations(out io.Writer, format string, conversations []AcknowledgedConversation) error {
+	records := make([]conversationRecord, 0, len(conversations))
+	for _, ac := range conversations {
+		records = append(records, conversationRecord{
+			ID:                    ac.ID,
+			Type:                  ac.ConversationType,
+			Name:                  ac.DisplayName,
+			LatestMsgTs:           ac.LatestMsgTs,
+			AcknowledgedThroughTs: ac.AcknowledgedThroughTs,
+			Unread:                ac.LatestMsgTs > ac.AcknowledgedThroughTs,
+			TeamID:                ac.TeamID,
+		})
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "tsv":
+		for _, r := range records {
+			_, err := fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", r.ID, r.Type, tsvEscaper.Replace(r.Name), r.LatestMsgTs, r.AcknowledgedThroughTs, r.Unread, r.TeamID)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		// with more than one workspace, say which each conversation is in
+		teams := make(map[string]bool)
+		for _, r := range records {
+			if r.TeamID != "" {
+				teams[r.TeamID] = true
+			}
+		}
+
+		for _, r := range records {
+			marker := " "
+			if r.Unread {
+				marker = "*"
+			}
+			team := ""
+			if teams[r.TeamID] && len(teams) > 1 {
+				team = r.TeamID + ": "
+			}
+			_, err := fmt.Fprintf(out, "%s %s%s\n", marker, team, r.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Find the conversation with the id or display name given, ignoring case
+// and a leading #.  Ids are only unique within a workspace, so an id in more
+// than one needs its team too, as TEAM/ID.
+func findConversation(conversations []AcknowledgedConversation, idOrName string) (AcknowledgedConversation, error) {
+	idMatches := make([]AcknowledgedConversation, 0)
+	matches := make([]AcknowledgedConversation, 0)
+	for _, ac := range conversations {
+		if ac.TeamID != "" && ac.TeamID+"/"+ac.ID == idOrName {
+			return ac, nil
+		}
+
+		if ac.ID == idOrName {
+			idMatches = append(idMatches, ac)
+		}
+
+		if strings.EqualFold(strings.TrimPrefix(ac.DisplayName, "#"), strings.TrimPrefix(idOrName, "#")) {
+			matches = append(matches, ac)
+		}
+	}
+
+	if len(idMatches) > 0 {
+		matches = idMatches
+	}
+
+	switch len(matches) {
+	case 0:
+		return AcknowledgedConversation{}, fmt.Errorf("No conversation %s", idOrName)
+	case 1:
+		return matches[0], nil
+	default:
+		ids := make([]string, 0, len(matches))
+		for _, ac := range matches {
+			ids = append(ids, qualifiedID(ac.Conversation))
+		}
+		return AcknowledgedConversation{}, fmt.Errorf("%s could be any of %s, so give one of those instead", idOrName, strings.Join(ids, ", "))
+	}
+}
+
+// The conversation's id with its team, as findConversation takes it.
+func qualifiedID(c Conversation) string {
+	if c.TeamID == "" {
+		return c.ID
+	}
+	return c.TeamID + "/" + c.ID
+}
+
+func findConversations(db *SlackBoxDB, idsOrNames []string) ([]AcknowledgedConversation, error) {
+	all, err := db.GetAcknowledgedConversations()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]AcknowledgedConversation, 0, len(idsOrNames))
+	for _, idOrName := range idsOrNames {
+		ac, err := findConversation(all, idOrName)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, ac)
+	}
+
+	return found, nil
+}
+
+func runListCommand(args []string, env *commandEnv) error {
+	format, args, err := parseCommandFlags("list", args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return &usageError{"Usage: slackbox list [--format f]"}
+	}
+
+	unacked, err := env.db.GetUnackedConversations()
+	if err != nil {
+		return err
+	}
+
+	return writeConversations(env.out, format, unacked)
+}
+
+// Ack (or unack) the conversations through their latest messages (or their
+// latest acks), through the journal so the TUI can undo it, then show them.
+func runAckCommand(args []string, env *commandEnv, action string) error {
+	format, args, err := parseCommandFlags(action, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return &usageError{fmt.Sprintf("Usage: slackbox %s [--format f] <id|name>...", action)}
+	}
+
+	conversations, err := findConversations(env.db, args)
+	if err != nil {
+		return err
+	}
+
+	for _, ac := range conversations {
+		if action == unackAction {
+			_, err = env.db.UnackLatest(ac.Conversation, noPosition)
+		} else {
+			err = env.db.Do(JournalEntry{Action: action, TeamID: ac.TeamID, ConversationID: ac.ID, Ts: ac.LatestMsgTs, Position: noPosition})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	conversations, err = findConversations(env.db, args)
+	if err != nil {
+		return err
+	}
+
+	return writeConversations(env.out, format, conversations)
+}
+
+func runRefreshCommand(args []string, env *commandEnv) error {
+	format, args, err := parseCommandFlags("refresh", args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return &usageError{"Usage: slackbox refresh [--format f]"}
+	}
+
+	apis, err := env.connectAPIs()
+	if err != nil {
+		return err
+	}
+
+	for _, api := range apis {
+		err = updateFromSlack(context.Background(), api, env.db, env.refresh)
+		if err != nil {
+			return fmt.Errorf("Error refreshing %s: %s", api.TeamName(), err)
+		}
+	}
+
+	unacked, err := env.db.GetUnackedConversations()
+	if err != nil {
+		return err
+	}
+
+	return writeConversations(env.out, format, unacked)
+}
+
+func runOpenCommand(args []string, env *commandEnv) error {
+	if len(args) != 1 {
+		return &usageError{"Usage: slackbox open <id|name>"}
+	}
+
+	conversations, err := findConversations(env.db, args)
+	if err != nil {
+		return err
+	}
+	ac := conversations[0]
+
+	apis, err := env.connectAPIs()
+	if err != nil {
+		return err
+	}
+
+	api, err := apiForTeam(apis, ac.TeamID)
+	if err != nil {
+		return err
+	}
+
+	link, err := api.FetchConversationLink(ac.SlackChannelID(), ac.GetBestLinkableTs())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(env.out, link)
+	return env.openURL(link)
+}
+
+func runDBCommand(args []string, env *commandEnv) error {
+	if len(args) != 1 || args[0] != "compact" {
+		return &usageError{"Usage: slackbox db compact"}
+	}
+
+	trimmed, err := env.db.Compact()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(env.out, "Trimmed %d acknowledgements and compacted the db\n", trimmed)
+	return nil
+}
+
+// The config file was checked as it was loaded, so all that's left is to
+// show the settings that came of it.
+func runConfigCommand(args []string, env *commandEnv) error {
+	if len(args) != 1 || args[0] != "check" {
+		return &usageError{"Usage: slackbox config check"}
+	}
+
+	fmt.Fprintln(env.out, "# the config is valid, and with any flags given comes to")
+	return toml.NewEncoder(env.out).Encode(env.settings)
+}
+
+// Encrypt a token with a passphrase asked for twice, refusing to overwrite
+// an existing file.
+func runTokenCommand(args []string, env *commandEnv) error {
+	if len(args) != 2 || args[0] != "encrypt" {
+		return &usageError{"Usage: slackbox token encrypt <path>"}
+	}
+	path := args[1]
+
+	token, err := env.readSecret("Slack token: ")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(token)) == "" {
+		return errors.New("No token given")
+	}
+
+	passphrase, err := readNewPassphrase(env.readSecret)
+	if err != nil {
+		return err
+	}
+
+	dat, err := encryptToken(strings.TrimSpace(string(token)), passphrase)
+	if err != nil {
+		return err
+	}
+
+	// unlike login, which replaces the token, never encrypt over a file
+	// that's already there
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %s", path, err)
+	}
+	_, err = f.Write(dat)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+
+	fmt.Fprintf(env.out, "Wrote the encrypted token to %s, read it with -tokenpath encrypted:%s\n", path, path)
+	return nil
+}
+
+// The port login listens on for slack's redirect unless told otherwise.
+const defaultLoginPort = 8976
+
+func runLoginCommand(args []string, env *commandEnv) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	clientID := flags.String("client-id", os.Getenv("SLACKBOX_CLIENT_ID"), "")
+	port := flags.Int("port", defaultLoginPort, "")
+
+	usage := "Usage: slackbox login [--client-id id] [--port n] [source]"
+	err := flags.Parse(args)
+	if err != nil {
+		return &usageError{fmt.Sprintf("Bad arguments to login: %s\n%s", err, usage)}
+	}
+	if flags.NArg() > 1 {
+		return &usageError{usage}
+	}
+
+	source := env.settings.TokenPaths[0]
+	if flags.NArg() == 1 {
+		source = flags.Arg(0)
+	}
+
+	clientSecret := os.Getenv("SLACKBOX_CLIENT_SECRET")
+	switch {
+	case *clientID == "":
+		return errors.New("No client id given, with --client-id or $SLACKBOX_CLIENT_ID")
+	case clientSecret == "":
+		return errors.New("No client secret given in $SLACKBOX_CLIENT_SECRET")
+	}
+
+	// check the token can be stored before going to the trouble of getting it
+	provider, err := parseTokenSource(source, env.readSecret)
+	if err != nil {
+		return err
+	}
+	if _, ok := provider.(tokenStore); !ok {
+		return fmt.Errorf("Can't store a token in %s, only in a file path or encrypted:path", source)
+	}
+
+	token, err := oauthLogin(oauthOptions{
+		slackURL:     env.slackURL,
+		clientID:     *clientID,
+		clientSecret: clientSecret,
+		port:         *port,
+		timeout:      5 * time.Minute,
+		openURL:      env.openURL,
+		out:          env.out,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = storeToken(source, token.token, env.readSecret)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(env.out, "Logged in to %s, and stored the token in %s\n", token.teamName, source)
+
+	// the user may have approved fewer scopes than asked for
+	missing := missingScopes(token.scopes, scopeRequirements(APIOptions{Channels: NewChannelFilter("all", ""), Mentions: true, Realtime: env.settings.Realtime}))
+	if len(missing) > 0 {
+		fmt.Fprintln(env.out, scopeReport(token.teamName, missing))
+	}
+	return nil
+}
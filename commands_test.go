@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func commandTestEnv(t *testing.T) (*commandEnv, *bytes.Buffer) {
+	db := memoryDB(t)
+
+	checkUpdate(t, db, Conversation{ID: "D1", ConversationType: "im", DisplayName: "alice", LatestMsgTs: "2.0", TeamID: "T1"})
+	checkUpdate(t, db, Conversation{ID: "C1", ConversationType: "channel", DisplayName: "#general", LatestMsgTs: "1.0"})
+	checkUpdate(t, db, Conversation{ID: "C2", ConversationType: "channel", DisplayName: "#random", LatestMsgTs: "3.0", TeamID: "TFAKE"})
+	checkUpdate(t, db, Conversation{ID: "C3", ConversationType: "channel", DisplayName: "#Random", LatestMsgTs: "0.5"})
+	checkAck(t, db, "TFAKE", "C2", "3.0")
+
+	out := &bytes.Buffer{}
+	env := &commandEnv{
+		db: db,
+		connectAPIs: func() ([]SlackAPI, error) {
+			return nil, errors.New("no slack in tests")
+		},
+		openURL: func(string) error {
+			return nil
+		},
+		out: out,
+	}
+
+	return env, out
+}
+
+func checkCommand(t *testing.T, env *commandEnv, out *bytes.Buffer, expected string, args ...string) {
+	out.Reset()
+	err := runCommand(args, env)
+	if err != nil {
+		t.Fatalf("Running %v failed with error %s", args, err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("Running %v expected output\n%s\ngot\n%s", args, expected, out.String())
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	env, out := commandTestEnv(t)
+
+	checkCommand(t, env, out, "* alice\n* #general\n* #Random\n", "list")
+	checkCommand(t, env, out, "D1\tim\talice\t2.0\t\ttrue\tT1\nC1\tchannel\t#general\t1.0\t\ttrue\t\nC3\tchannel\t#Random\t0.5\t\ttrue\t\n", "list", "--format", "tsv")
+
+	out.Reset()
+	err := runCommand([]string{"list", "--format=json"}, env)
+	if err != nil {
+		t.Fatalf("Listing failed with error %s", err)
+	}
+
+	var records []conversationRecord
+	err = json.Unmarshal(out.Bytes(), &records)
+	if err != nil {
+		t.Fatalf("Failed parsing json output %s", err)
+	}
+	if len(records) != 3 || records[0] != (conversationRecord{ID: "D1", Type: "im", Name: "alice", LatestMsgTs: "2.0", Unread: true, TeamID: "T1"}) {
+		t.Errorf("Unexpected json output %v", records)
+	}
+}
+
+func TestAckCommands(t *testing.T) {
+	env, out := commandTestEnv(t)
+
+	checkCommand(t, env, out, "  alice\n  #general\n", "ack", "D1", "general")
+	checkCommand(t, env, out, "* #Random\n", "list")
+
+	checkCommand(t, env, out, "* alice\n", "unack", "alice")
+	checkCommand(t, env, out, "* alice\n* #Random\n", "list")
+
+	// acks from the command line can be undone like those in the inbox
+	_, found, err := env.db.Undo()
+	if err != nil || !found {
+		t.Fatalf("Expected to undo the unack, got %t %s", found, err)
+	}
+	checkCommand(t, env, out, "* #Random\n", "list")
+}
+
+// Ids are only unique within a workspace, so one in two needs its team.
+func TestAckCommandsWithSharedID(t *testing.T) {
+	env, out := commandTestEnv(t)
+	checkUpdate(t, env.db, Conversation{ID: "D1", ConversationType: "im", DisplayName: "bob", LatestMsgTs: "1.0", TeamID: "T2"})
+
+	err := runCommand([]string{"ack", "D1"}, env)
+	if err == nil || !strings.Contains(err.Error(), "could be any of T1/D1, T2/D1") {
+		t.Errorf("Expected D1 to be ambiguous, got %v", err)
+	}
+
+	checkCommand(t, env, out, "  bob\n", "ack", "T2/D1")
+	checkCommand(t, env, out, "* alice\n* #general\n* #Random\n", "list")
+	checkCommand(t, env, out, "* T1: alice\n* T2: bob\n", "unack", "T1/D1", "bob")
+}
+
+func TestCommandErrors(t *testing.T) {
+	env, _ := commandTestEnv(t)
+
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"nonsense"}, "Unknown command"},
+		{[]string{"list", "--format", "xml"}, "Unknown format"},
+		{[]string{"list", "extra"}, "Usage"},
+		{[]string{"ack"}, "Usage"},
+		{[]string{"ack", "nobody"}, "No conversation nobody"},
+		{[]string{"ack", "random"}, "could be any of TFAKE/C2, C3"},
+		{[]string{"refresh"}, "no slack in tests"},
+		{[]string{"open", "alice"}, "no slack in tests"},
+	}
+
+	for _, c := range cases {
+		err := runCommand(c.args, env)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Running %v expected error containing %q, got %v", c.args, c.expected, err)
+		}
+	}
+}
+
+func TestRefreshAndOpenCommands(t *testing.T) {
+	env, out := commandTestEnv(t)
+
+	fake := newFakeSlack()
+	fake.conversations = []Conversation{{ID: "C2", ConversationType: "channel", DisplayName: "#random", LatestMsgTs: "4.0", TeamID: "TFAKE"}}
+	env.connectAPIs = func() ([]SlackAPI, error) {
+		return []SlackAPI{fake}, nil
+	}
+
+	opened := ""
+	env.openURL = func(url string) error {
+		opened = url
+		return nil
+	}
+
+	checkCommand(t, env, out, "* TFAKE: #random\n* T1: alice\n* #general\n* #Random\n", "refresh")
+
+	// #random is acked through 3.0, so that's where it opens
+	checkCommand(t, env, out, "https://fake.slack.com/archives/C2/p3.0\n", "open", "C2")
+	if opened != "https://fake.slack.com/archives/C2/p3.0" {
+		t.Errorf("Expected the link opened, got %s", opened)
+	}
+}
+
+func TestDBCompactCommand(t *testing.T) {
+	env, out := commandTestEnv(t)
+	checkCommand(t, env, out, "Trimmed 0 acknowledgements and compacted the db\n", "db", "compact")
+}
+
+func TestConfigCheckCommand(t *testing.T) {
+	out := &bytes.Buffer{}
+	s := defaultSettings()
+	s.DBPath = "/tmp/work.db"
+	s.RefreshInterval = duration{5 * time.Minute}
+	env := &commandEnv{settings: s, out: out}
+
+	err := runCommand([]string{"config", "check"}, env)
+	if err != nil {
+		t.Fatalf("Checking config failed with error %s", err)
+	}
+
+	// what's shown reads back as the same settings
+	shown := defaultSettings()
+	_, err = toml.Decode(out.String(), &shown)
+	if err != nil {
+		t.Fatalf("Failed parsing the settings shown %s\n%s", err, out.String())
+	}
+	if !reflect.DeepEqual(s, shown) {
+		t.Errorf("Expected settings %v shown, got %v", s, shown)
+	}
+
+	err = runCommand([]string{"config"}, env)
+	if err == nil {
+		t.Errorf("Expected an error for config without check")
+	}
+}
+
+func TestTokenEncryptCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackbox-tokens")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token.enc")
+
+	out := &bytes.Buffer{}
+	env := &commandEnv{readSecret: fakeSecrets(t, "xoxp-1234", "hunter2", "hunter3"), out: out}
+	err = runCommand([]string{"token", "encrypt", path}, env)
+	if err == nil || !strings.Contains(err.Error(), "don't match") {
+		t.Errorf("Expected an error for mismatched passphrases, got %v", err)
+	}
+
+	env.readSecret = fakeSecrets(t, "xoxp-1234", "hunter2", "hunter2")
+	err = runCommand([]string{"token", "encrypt", path}, env)
+	if err != nil {
+		t.Fatalf("Encrypting the token failed with error %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Could not stat %s %s", path, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the token written 0600, got %s", info.Mode().Perm())
+	}
+
+	provider, err := parseTokenSource("encrypted:"+path, fakeSecrets(t, "hunter2"))
+	if err != nil {
+		t.Fatalf("Parsing the token source failed with error %s", err)
+	}
+	token, err := provider.Token()
+	if err != nil || token != "xoxp-1234" {
+		t.Errorf("Expected token xoxp-1234 read back, got %q %v", token, err)
+	}
+
+	// an existing file is never overwritten
+	env.readSecret = fakeSecrets(t, "xoxp-5678", "hunter2", "hunter2")
+	err = runCommand([]string{"token", "encrypt", path}, env)
+	if err == nil {
+		t.Errorf("Expected an error encrypting over an existing file")
+	}
+	provider, _ = parseTokenSource("encrypted:"+path, fakeSecrets(t, "hunter2"))
+	if token, err := provider.Token(); err != nil || token != "xoxp-1234" {
+		t.Errorf("Expected the existing token xoxp-1234 kept, got %q %v", token, err)
+	}
+}
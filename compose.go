@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+// Open an input line under the inbox for replying to the selected
+// conversation, in a thread off its latest message if inThread.  Replies to a
+// thread, or to a mention in one, always go to the thread.  Enter sends the
+// reply, Escape abandons it.
+func (ib *inbox) showCompose(inThread bool) {
+	ac, ok := ib.selectedConversation()
+	if !ok {
+		return
+	}
+
+	api, err := ib.apiFor(ac.Conversation)
+	if err != nil {
+		ib.showModal(fmt.Sprintf("%s", err))
+		return
+	}
+
+	threadTs := ""
+	startsThread := false
+	label := fmt.Sprintf("Reply to %s: ", ac.DisplayName)
+	switch {
+	case ac.ThreadTs != "":
+		threadTs = ac.ThreadTs
+	case inThread:
+		threadTs = ac.LatestMsgTs
+		// mentions are in conversations we don't track, so neither are
+		// their threads
+		startsThread = api.FollowsThreads() && ac.ConversationType != "mention"
+		label = fmt.Sprintf("Reply in thread to %s: ", ac.DisplayName)
+	}
+
+	input := tview.NewInputField()
+	input.SetLabel(tview.Escape(label))
+
+	input.SetDoneFunc(func(key tcell.Key) {
+		text := input.GetText()
+		if key != tcell.KeyEnter || text == "" {
+			ib.app.SetRoot(ib.root, true)
+			return
+		}
+
+		input.SetLabel("Sending... ")
+		input.SetDoneFunc(nil)
+
+		go func() {
+			reply, err := ib.postReply(api, ac, text, threadTs, startsThread)
+
+			ib.app.QueueUpdateDraw(func() {
+				ib.app.SetRoot(ib.root, true)
+				if err == nil {
+					err = ib.ackReply(ac, reply)
+				}
+				if err != nil {
+					ib.showModal(fmt.Sprintf("%s", err))
+				}
+				ib.reloadList()
+			})
+		}()
+	})
+
+	composing := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ib.root, 0, 1, false).
+		AddItem(input, 1, 0, true)
+	ib.app.SetRoot(composing, true)
+}
+
+// A reply that's been sent, and the thread it started, if it's one to follow.
+type sentReply struct {
+	ts     string
+	thread *Conversation
+}
+
+// Post the reply to the conversation, in the thread threadTs if it isn't
+// blank, fetching the thread if startsThread says to follow it.  Safe to call
+// off the UI goroutine.
+func (ib *inbox) postReply(api SlackAPI, ac AcknowledgedConversation, text string, threadTs string, startsThread bool) (sentReply, error) {
+	ts, err := api.PostMessage(ib.ctx, ac.SlackChannelID(), text, threadTs)
+	if err != nil {
+		return sentReply{}, err
+	}
+
+	reply := sentReply{ts: ts}
+	if startsThread {
+		// a thread we reply in is one we want to follow
+		thread, err := api.FetchThread(ib.ctx, newThread(ac.Conversation, threadTs), ac.DisplayName)
+		if err != nil {
+			return reply, err
+		}
+		reply.thread = &thread
+	}
+
+	return reply, nil
+}
+
+// Ack what replying shows was read.
+func (ib *inbox) ackReply(ac AcknowledgedConversation, reply sentReply) error {
+	switch {
+	case reply.thread != nil:
+		// the reply isn't in the conversation itself, only in the thread
+		err := ib.ackSent(ac.Conversation, "")
+		if err != nil {
+			return err
+		}
+		return ib.ackSent(*reply.thread, reply.ts)
+	case ac.ConversationType == "mention":
+		// a mention is only ever the one message
+		return ib.ackSent(ac.Conversation, "")
+	default:
+		return ib.ackSent(ac.Conversation, reply.ts)
+	}
+}
+
+// Having replied, there's nothing left to read up to and including our own
+// reply (if sentTs isn't blank), so ack the conversation through it.
+func (ib *inbox) ackSent(conversation Conversation, sentTs string) error {
+	if sentTs > conversation.LatestMsgTs {
+		conversation.LatestMsgTs = sentTs
+	}
+
+	err := ib.db.UpdateConversation(conversation)
+	if err != nil {
+		return err
+	}
+
+	return ib.db.AckConversation(conversation.TeamID, conversation.ID, conversation.LatestMsgTs)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Send text as a reply to c and ack what it shows was read, as the compose
+// line does.
+func checkReply(t *testing.T, ib *inbox, api SlackAPI, c Conversation, text string, threadTs string, startsThread bool) sentReply {
+	ac := AcknowledgedConversation{Conversation: c}
+	reply, err := ib.postReply(api, ac, text, threadTs, startsThread)
+	if err != nil {
+		t.Fatalf("postReply failed with error %s", err)
+	}
+	err = ib.ackReply(ac, reply)
+	if err != nil {
+		t.Fatalf("ackReply failed with error %s", err)
+	}
+	return reply
+}
+
+// How far each conversation is acked.
+func ackedThrough(t *testing.T, db *SlackBoxDB) map[string]string {
+	acked, err := db.GetAcknowledgedConversations()
+	if err != nil {
+		t.Fatalf("GetAcknowledgedConversations failed with error %s", err)
+	}
+	through := make(map[string]string)
+	for _, ac := range acked {
+		through[ac.ID] = ac.AcknowledgedThroughTs
+	}
+	return through
+}
+
+func TestReplyAcksThroughIt(t *testing.T) {
+	db := memoryDB(t)
+	ib := &inbox{ctx: context.Background(), db: db}
+	api := newFakeSlack()
+
+	c := Conversation{ID: "D1", ConversationType: "im", DisplayName: "Alice", LatestMsgTs: "1.000000", TeamID: "TFAKE"}
+	checkUpdate(t, db, c)
+
+	reply := checkReply(t, ib, api, c, "hi", "", false)
+	if len(api.posted) != 1 || api.posted[0].Text != "hi" || len(api.messages[threadID("D1", "")]) != 1 {
+		t.Errorf("Expected hi posted to D1, got %v", api.messages)
+	}
+	if acked := ackedThrough(t, db); acked["D1"] != reply.ts {
+		t.Errorf("Expected D1 acked through the reply %s, got %s", reply.ts, acked["D1"])
+	}
+	if got := checkGet(t, db, c.TeamID, "D1"); got.LatestMsgTs != reply.ts {
+		t.Errorf("Expected D1's latest message to be the reply, got %s", got.LatestMsgTs)
+	}
+	if len(api.fetchedThreads) != 0 {
+		t.Errorf("Expected no threads followed, got %v", api.fetchedThreads)
+	}
+}
+
+func TestReplyInThreadFollowsIt(t *testing.T) {
+	db := memoryDB(t)
+	ib := &inbox{ctx: context.Background(), db: db}
+	api := newFakeSlack()
+
+	c := Conversation{ID: "C1", ConversationType: "channel", DisplayName: "#general", LatestMsgTs: "1.000000", TeamID: "TFAKE"}
+	checkUpdate(t, db, c)
+
+	reply := checkReply(t, ib, api, c, "hi", c.LatestMsgTs, true)
+	if len(api.messages["C1/1.000000"]) != 1 || len(api.messages[threadID("C1", "")]) != 0 {
+		t.Errorf("Expected hi posted in the thread, got %v", api.messages)
+	}
+	if len(api.fetchedThreads) != 1 || api.fetchedThreads[0] != "C1/1.000000" {
+		t.Errorf("Expected the thread C1/1.000000 fetched, got %v", api.fetchedThreads)
+	}
+
+	acked := ackedThrough(t, db)
+	if acked["C1"] != "1.000000" {
+		t.Errorf("Expected C1 acked through the message replied to, got %s", acked["C1"])
+	}
+	if acked["C1/1.000000"] != reply.ts {
+		t.Errorf("Expected the thread acked through the reply %s, got %s", reply.ts, acked["C1/1.000000"])
+	}
+	thread := checkGet(t, db, c.TeamID, "C1/1.000000")
+	if thread.ConversationType != "thread" || thread.ChannelID != "C1" || thread.ThreadTs != "1.000000" {
+		t.Errorf("Expected the thread followed, got %v", thread)
+	}
+}
+
+func TestReplyToMentionAcksIt(t *testing.T) {
+	db := memoryDB(t)
+	ib := &inbox{ctx: context.Background(), db: db}
+	api := newFakeSlack()
+
+	mention := Conversation{
+		ID: mentionID("C9", "5.000000"), ConversationType: "mention", DisplayName: "#random",
+		LatestMsgTs: "5.000000", TeamID: "TFAKE", ChannelID: "C9",
+	}
+	checkUpdate(t, db, mention)
+
+	checkReply(t, ib, api, mention, "hi", mention.LatestMsgTs, false)
+	if len(api.messages["C9/5.000000"]) != 1 {
+		t.Errorf("Expected hi posted in the mention's thread, got %v", api.messages)
+	}
+	if acked := ackedThrough(t, db); acked[mention.ID] != "5.000000" {
+		t.Errorf("Expected the mention acked, got %s", acked[mention.ID])
+	}
+	if len(api.fetchedThreads) != 0 {
+		t.Errorf("Expected no threads followed, got %v", api.fetchedThreads)
+	}
+}
+
+func TestFailedReplyAcksNothing(t *testing.T) {
+	db := memoryDB(t)
+	ib := &inbox{ctx: context.Background(), db: db}
+	api := newFakeSlack()
+	api.err = errors.New("channel_not_found")
+
+	c := Conversation{ID: "D1", ConversationType: "im", DisplayName: "Alice", LatestMsgTs: "1.000000", TeamID: "TFAKE"}
+	checkUpdate(t, db, c)
+
+	_, err := ib.postReply(api, AcknowledgedConversation{Conversation: c}, "hi", "", false)
+	if err == nil {
+		t.Error("Expected the post to fail")
+	}
+	checkUnacked(t, db, []Conversation{c})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+// A duration written in the config file as e.g. "5m" or "336h".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// The colors of the TUI, by tcell name (e.g. "green") or "#rrggbb".  Blank
+// keeps tview's default.
+type theme struct {
+	Background string `toml:"background"`
+	Text       string `toml:"text"`
+	Border     string `toml:"border"`
+	// conversations with new messages since you last moved onto them
+	Arrived string `toml:"arrived"`
+	// headers, timestamps and other less important text
+	Muted string `toml:"muted"`
+	Error string `toml:"error"`
+}
+
+func defaultTheme() theme {
+	return theme{Arrived: "green", Muted: "gray", Error: "red"}
+}
+
+func checkColor(name string, color string) error {
+	if color == "" {
+		return nil
+	}
+	if _, found := tcell.ColorNames[color]; found {
+		return nil
+	}
+	if len(color) == 7 && color[0] == '#' && tcell.GetColor(color) != tcell.ColorDefault {
+		return nil
+	}
+	return fmt.Errorf("Unknown color %s for %s", color, name)
+}
+
+func (t theme) check() error {
+	colors := [][2]string{
+		{"background", t.Background}, {"text", t.Text}, {"border", t.Border},
+		{"arrived", t.Arrived}, {"muted", t.Muted}, {"error", t.Error},
+	}
+	for _, color := range colors {
+		err := checkColor(color[0], color[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The color to use in a tview color tag, where - is the default.
+func tagColor(color string) string {
+	if color == "" {
+		return "-"
+	}
+	return color
+}
+
+// Set the colors tview gives its primitives.  Must be called before any are
+// made.
+func (t theme) apply() {
+	if t.Background != "" {
+		tview.Styles.PrimitiveBackgroundColor = tcell.GetColor(t.Background)
+	}
+	if t.Text != "" {
+		tview.Styles.PrimaryTextColor = tcell.GetColor(t.Text)
+	}
+	if t.Border != "" {
+		tview.Styles.BorderColor = tcell.GetColor(t.Border)
+		tview.Styles.TitleColor = tcell.GetColor(t.Border)
+	}
+}
+
+// Everything slackbox can be configured with, from the config file and
+// flags.
+type settings struct {
+	TokenPaths      []string `toml:"tokenpaths"`
+	DBPath          string   `toml:"dbpath"`
+	Channels        []string `toml:"channels"`
+	ExcludeChannels []string `toml:"exclude_channels"`
+	Workers         int      `toml:"workers"`
+	UserTTL         duration `toml:"user_ttl"`
+	Threads         bool     `toml:"threads"`
+	ThreadLookback  duration `toml:"thread_lookback"`
+	Mentions        bool     `toml:"mentions"`
+	RefreshInterval duration `toml:"refresh_interval"`
+	Realtime        bool     `toml:"realtime"`
+	AckHistory      int      `toml:"ack_history"`
+	// the command to open links with, given the link as its last argument
+	// or in place of a %s, or blank for the system's default browser
+	Browser string      `toml:"browser"`
+	Keys    keyBindings `toml:"keys"`
+	Theme   theme       `toml:"theme"`
+}
+
+func defaultSettings() settings {
+	return settings{
+		TokenPaths:      []string{"tokenfile.txt"},
+		DBPath:          "slackbox.db",
+		Channels:        []string{},
+		ExcludeChannels: []string{},
+		Workers:         8,
+		UserTTL:         duration{24 * time.Hour},
+		Threads:         true,
+		ThreadLookback:  duration{14 * 24 * time.Hour},
+		Mentions:        true,
+		Realtime:        true,
+		AckHistory:      DefaultAckHistory,
+		Keys:            defaultKeyBindings(),
+		Theme:           defaultTheme(),
+	}
+}
+
+// The config file: settings, which may be overridden by one of its named
+// profiles, chosen with -profile or else by its profile setting.
+type configFile struct {
+	settings
+	Profile  string                    `toml:"profile"`
+	Profiles map[string]toml.Primitive `toml:"profiles"`
+}
+
+// Where the config file is read from unless -config says otherwise.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "slackbox", "config.toml")
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// A copy of the settings that can be decoded over without changing them.
+func (s settings) clone() settings {
+	keys := make(keyBindings, len(s.Keys))
+	for action, key := range s.Keys {
+		keys[action] = key
+	}
+	s.Keys = keys
+	return s
+}
+
+// Read the settings from the config file at path, with the named profile's
+// (or if blank, the file's chosen profile's) on top.  Every profile is
+// checked, not just the one used.  A missing file is only an error if it
+// was asked for.
+func loadSettings(path string, profile string) (settings, error) {
+	if path == "" {
+		path = defaultConfigPath()
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if profile != "" {
+				return settings{}, fmt.Errorf("No profile %s, as there's no config file at %s", profile, path)
+			}
+			return defaultSettings(), nil
+		}
+	}
+
+	file := configFile{settings: defaultSettings()}
+	md, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return settings{}, fmt.Errorf("Error reading config file %s: %s", path, err)
+	}
+
+	if profile == "" {
+		profile = file.Profile
+	}
+
+	names := make([]string, 0, len(file.Profiles))
+	for name := range file.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	chosen, found := file.settings, profile == ""
+	for _, name := range names {
+		s := file.settings.clone()
+		err = md.PrimitiveDecode(file.Profiles[name], &s)
+		if err == nil {
+			err = s.finish()
+		}
+		if err != nil {
+			return settings{}, fmt.Errorf("Error in profile %s of %s: %s", name, path, err)
+		}
+
+		if name == profile {
+			chosen, found = s, true
+		}
+	}
+
+	if !found {
+		return settings{}, fmt.Errorf("No profile %s in %s", profile, path)
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return settings{}, fmt.Errorf("Unknown settings %s in %s", strings.Join(keys, ", "), path)
+	}
+
+	err = chosen.finish()
+	if err != nil {
+		return settings{}, fmt.Errorf("Error in %s: %s", path, err)
+	}
+
+	return chosen, nil
+}
+
+// Expand ~ in paths and check everything makes sense.  Safe to call more
+// than once.
+func (s *settings) finish() error {
+	_, err := s.Keys.actions()
+	if err != nil {
+		return err
+	}
+
+	err = s.Theme.check()
+	if err != nil {
+		return err
+	}
+
+	tokenPaths := make([]string, 0, len(s.TokenPaths))
+	for _, path := range s.TokenPaths {
+		tokenPaths = append(tokenPaths, expandHome(path))
+	}
+	s.TokenPaths = tokenPaths
+	s.DBPath = expandHome(s.DBPath)
+
+	switch {
+	case len(s.TokenPaths) == 0:
+		return errors.New("No tokenpaths given")
+	case s.Workers < 1:
+		return fmt.Errorf("Workers must be at least 1, not %d", s.Workers)
+	case s.AckHistory < 1:
+		return fmt.Errorf("Ack history must be at least 1, not %d", s.AckHistory)
+	case s.UserTTL.Duration < 0, s.ThreadLookback.Duration < 0, s.RefreshInterval.Duration < 0:
+		return errors.New("Durations can't be negative")
+	}
+
+	return nil
+}
+
+// Open links with the browser command, or the system's default browser if
+// it's blank.
+func (s settings) openURL(defaultOpen func(string) error) func(string) error {
+	if strings.TrimSpace(s.Browser) == "" {
+		return defaultOpen
+	}
+
+	return func(url string) error {
+		args := strings.Fields(s.Browser)
+		if strings.Contains(s.Browser, "%s") {
+			for i, arg := range args {
+				args[i] = strings.Replace(arg, "%s", url, -1)
+			}
+		} else {
+			args = append(args, url)
+		}
+
+		cmd := exec.Command(args[0], args[1:]...)
+		err := cmd.Start()
+		if err != nil {
+			return fmt.Errorf("Error opening %s with %s: %s", url, s.Browser, err)
+		}
+		// reap it once the browser's done, without waiting
+		go cmd.Wait()
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "slackbox-config")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	path := filepath.Join(dir, "config.toml")
+	err = ioutil.WriteFile(path, []byte(config), 0600)
+	if err != nil {
+		t.Fatalf("Could not write config %s", err)
+	}
+	return path
+}
+
+const testConfig = `
+profile = "work"
+dbpath = "/tmp/slackbox.db"
+channels = ["all"]
+exclude_channels = ["random"]
+refresh_interval = "5m"
+
+[keys]
+ack = "x"
+
+[theme]
+arrived = "#00ff00"
+
+[profiles.work]
+tokenpaths = ["/tmp/work-token", "/tmp/club-token"]
+browser = "firefox --new-window"
+
+[profiles.home]
+dbpath = "/tmp/home.db"
+refresh_interval = "1h"
+
+[profiles.home.keys]
+ack = "y"
+`
+
+func TestLoadSettings(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	s, err := loadSettings(path, "")
+	if err != nil {
+		t.Fatalf("loadSettings failed with error %s", err)
+	}
+
+	// the file's own profile is used on top of its settings
+	expected := defaultSettings()
+	expected.TokenPaths = []string{"/tmp/work-token", "/tmp/club-token"}
+	expected.DBPath = "/tmp/slackbox.db"
+	expected.Channels = []string{"all"}
+	expected.ExcludeChannels = []string{"random"}
+	expected.RefreshInterval = duration{5 * time.Minute}
+	expected.Browser = "firefox --new-window"
+	expected.Keys[ackKey] = "x"
+	expected.Theme.Arrived = "#00ff00"
+	if !reflect.DeepEqual(expected, s) {
+		t.Errorf("Expected settings %v, got %v", expected, s)
+	}
+
+	s, err = loadSettings(path, "home")
+	if err != nil {
+		t.Fatalf("loadSettings failed with error %s", err)
+	}
+	if s.DBPath != "/tmp/home.db" || s.RefreshInterval.Duration != time.Hour || s.Keys[ackKey] != "y" {
+		t.Errorf("Expected the home profile's settings, got %v", s)
+	}
+	if !reflect.DeepEqual(s.TokenPaths, defaultSettings().TokenPaths) || s.Browser != "" {
+		t.Errorf("Expected nothing from the work profile, got %v", s)
+	}
+}
+
+func TestLoadSettingsDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackbox-config")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer os.Setenv("XDG_CONFIG_HOME", oldConfigHome)
+
+	s, err := loadSettings("", "")
+	if err != nil {
+		t.Fatalf("loadSettings failed with error %s", err)
+	}
+	if !reflect.DeepEqual(defaultSettings(), s) {
+		t.Errorf("Expected the default settings without a config file, got %v", s)
+	}
+
+	_, err = loadSettings("", "work")
+	if err == nil {
+		t.Errorf("Expected an error asking for a profile without a config file")
+	}
+
+	_, err = loadSettings(filepath.Join(dir, "missing.toml"), "")
+	if err == nil {
+		t.Errorf("Expected an error asking for a missing config file")
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	cases := []struct {
+		config   string
+		profile  string
+		expected string
+	}{
+		{`dbpath = `, "", "Error reading config file"},
+		{`db_path = "x.db"`, "", "Unknown settings db_path"},
+		{"[profiles.work]\ncolour = \"red\"", "", "Unknown settings profiles.work.colour"},
+		{`workers = "many"`, "", "Error reading config file"},
+		{`refresh_interval = "soon"`, "", "Error reading config file"},
+		{`workers = 0`, "", "Workers must be at least 1"},
+		{`profile = "work"`, "", "No profile work"},
+		{"[profiles.home]", "work", "No profile work"},
+		{"[keys]\nack = \"u\"", "", `"u" is bound to both ack and unack`},
+		{"[keys]\nfly = \"f\"", "", "No action fly"},
+		{"[theme]\nmuted = \"greyish\"", "", "Unknown color greyish for muted"},
+		// every profile is checked, not just the one used
+		{"[profiles.home]\n[profiles.bad.theme]\nerror = \"#12345\"", "home", "Error in profile bad"},
+	}
+
+	for _, c := range cases {
+		path := writeConfig(t, c.config)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := loadSettings(path, c.profile)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Loading %q expected error containing %q, got %v", c.config, c.expected, err)
+		}
+	}
+}
+
+func TestKeyNames(t *testing.T) {
+	keys := defaultKeyBindings()
+	keys[ackKey] = ""
+
+	if keys.names(helpKey) != "? or h" {
+		t.Errorf("Expected help on ? or h, got %s", keys.names(helpKey))
+	}
+	if keys.names(ackKey) != "(unbound)" {
+		t.Errorf("Expected ack unbound, got %s", keys.names(ackKey))
+	}
+
+	actions, err := keys.actions()
+	if err != nil {
+		t.Fatalf("actions failed with error %s", err)
+	}
+	if actions['?'] != helpKey || actions['h'] != helpKey || actions['r'] != "" {
+		t.Errorf("Unexpected actions %v", actions)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const SupportedDBVersion = 1
+// Must match the version of the last of the migrations.
+const SupportedDBVersion = 8
 
 type AcknowledgedConversation struct {
 	Conversation
@@ -32,22 +34,33 @@ func (e *unsupportedVersionError) Error() string {
 	return fmt.Sprintf("Actual version %d, supported version %d", e.actualVersion, e.supportedVersion)
 }
 
+// How many acks are kept per conversation unless SetAckHistory says otherwise.
+const DefaultAckHistory = 10
+
 // Simple struct to hide the sqlite3 details.
 type SlackBoxDB struct {
-	db *sql.DB
+	db         *sql.DB
+	ackHistory int
 }
 
 func (db *SlackBoxDB) UpdateConversation(conversation Conversation) error {
 	sql := `
-      insert into conversations 
-        (id, conversation_type, display_name, latest_msg_ts)
+      insert into conversations
+        (id, conversation_type, display_name, latest_msg_ts, team_id, channel_id, thread_ts)
       values
-        (?,  ?,                 ?,            ?)
-      on conflict (id)
+        (?,  ?,                 ?,            ?,             ?,       ?,          ?)
+      on conflict (team_id, id)
       do update set
+      -- slack's word on what the conversation is and what it's called is
+      -- always the latest, which repairs rows stored wrongly before, e.g.
+      -- group DMs stored as IMs
+      conversation_type = excluded.conversation_type,
       display_name = excluded.display_name,
-      latest_msg_ts = excluded.latest_msg_ts
-      where excluded.latest_msg_ts > latest_msg_ts
+      -- but the latest message never goes backwards
+      latest_msg_ts = case
+        when excluded.latest_msg_ts > coalesce(latest_msg_ts, '') then excluded.latest_msg_ts
+        else latest_msg_ts
+      end
         `
 	stmt, err := db.db.Prepare(sql)
 	if err != nil {
@@ -56,7 +69,7 @@ func (db *SlackBoxDB) UpdateConversation(conversation Conversation) error {
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(conversation.ID, conversation.ConversationType, conversation.DisplayName, conversation.LatestMsgTs)
+	_, err = stmt.Exec(conversation.ID, conversation.ConversationType, conversation.DisplayName, conversation.LatestMsgTs, conversation.TeamID, conversation.ChannelID, conversation.ThreadTs)
 	if err != nil {
 		return err
 	}
@@ -75,18 +88,20 @@ func (db *SlackBoxDB) UpdateConversations(conversations []Conversation) error {
 	return nil
 }
 
-func (db *SlackBoxDB) GetConversation(conversationID string) (Conversation, bool, error) {
+// Find the conversation with the id given in the workspace.
+func (db *SlackBoxDB) GetConversation(teamID string, conversationID string) (Conversation, bool, error) {
 	c := Conversation{}
 
 	query := `
-    select 
-      id, conversation_type, display_name, latest_msg_ts
+    select
+      id, conversation_type, display_name, latest_msg_ts, team_id, channel_id, thread_ts
     from
       conversations
     where
-      id = ? 
+      team_id = ?
+      and id = ?
     `
-	rows, err := db.db.Query(query, conversationID)
+	rows, err := db.db.Query(query, teamID, conversationID)
 	if err != nil {
 		return c, false, err
 	}
@@ -98,7 +113,7 @@ func (db *SlackBoxDB) GetConversation(conversationID string) (Conversation, bool
 		return c, false, nil
 	}
 
-	err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs)
+	err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.TeamID, &c.ChannelID, &c.ThreadTs)
 	if err != nil {
 		return c, false, err
 	}
@@ -116,78 +131,361 @@ func (db *SlackBoxDB) GetConversation(conversationID string) (Conversation, bool
 	return c, true, nil
 }
 
-func (db *SlackBoxDB) AckConversation(id string, ackTs string) error {
-	// TODO trim the acks as part of this
+// The threads we follow in the workspace that have had a message since
+// sinceTs.
+func (db *SlackBoxDB) GetThreads(teamID string, sinceTs string) ([]Conversation, error) {
+	threads := make([]Conversation, 0)
+
+	query := `
+    select
+      id, conversation_type, display_name, latest_msg_ts, team_id, channel_id, thread_ts
+    from
+      conversations
+    where
+      conversation_type = 'thread'
+      and team_id = ?
+      and latest_msg_ts >= ?
+    order by
+      latest_msg_ts desc,
+      id asc
+    `
+	rows, err := db.db.Query(query, teamID, sinceTs)
+	if err != nil {
+		return threads, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Conversation{}
+		err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.TeamID, &c.ChannelID, &c.ThreadTs)
+		if err != nil {
+			return threads, err
+		}
+
+		threads = append(threads, c)
+	}
+
+	return threads, rows.Err()
+}
+
+// The ts of the newest mention we know of in the workspace, or blank if
+// there are none.
+func (db *SlackBoxDB) GetLatestMentionTs(teamID string) (string, error) {
+	query := `
+    select
+      coalesce(max(latest_msg_ts), '')
+    from
+      conversations
+    where
+      conversation_type = 'mention'
+      and team_id = ?
+    `
+	var latestMentionTs string
+	err := db.db.QueryRow(query, teamID).Scan(&latestMentionTs)
+	return latestMentionTs, err
+}
+
+// Give the conversations, acks, snoozes and users stored before slackbox
+// followed more than one workspace to the workspace with the id given.  Any
+// the workspace already has a row of its own for are left unowned.
+func (db *SlackBoxDB) ClaimUnowned(teamID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"conversations", "acknowledgements", "snoozes", "journal", "journal_removed_acks", "users"} {
+		_, err = tx.Exec("update or ignore "+table+" set team_id = ? where team_id = ''", teamID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Map the id of each conversation in the workspace to the ts of its latest
+// known message.
+func (db *SlackBoxDB) GetLatestMsgTimestamps(teamID string) (map[string]string, error) {
+	timestamps := make(map[string]string)
+
+	query := `
+    select
+      id, coalesce(latest_msg_ts, '')
+    from
+      conversations
+    where
+      team_id = ?
+    `
+	rows, err := db.db.Query(query, teamID)
+	if err != nil {
+		return timestamps, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, ts string
+		err = rows.Scan(&id, &ts)
+		if err != nil {
+			return timestamps, err
+		}
+
+		timestamps[id] = ts
+	}
+
+	return timestamps, rows.Err()
+}
+
+// Set how many of the most recent acks to keep for each conversation when
+// trimming.  Older acks are only needed to step back through with unack, so
+// depth must be at least 1.
+func (db *SlackBoxDB) SetAckHistory(depth int) {
+	if depth < 1 {
+		depth = 1
+	}
+	db.ackHistory = depth
+}
+
+func (db *SlackBoxDB) AckConversation(teamID string, id string, ackTs string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = db.ack(tx, teamID, id, ackTs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (db *SlackBoxDB) ack(tx execer, teamID string, id string, ackTs string) error {
 	sql := `
       insert into acknowledgements
-        (conversation_id, acknowledged_through_ts)
+        (team_id, conversation_id, acknowledged_through_ts, acknowledged_at)
       values
-        (?,               ?)
-      on conflict(conversation_id, acknowledged_through_ts) do nothing
+        (?,       ?,               ?,                       strftime('%s', 'now'))
+      on conflict(team_id, conversation_id, acknowledged_through_ts)
+      do update set
+      acknowledged_at = excluded.acknowledged_at
     `
 
-	_, err := db.db.Exec(sql, id, ackTs)
+	_, err := tx.Exec(sql, teamID, id, ackTs)
+	if err != nil {
+		return err
+	}
+
+	_, err = trimAcks(tx, teamID, id, db.ackHistory)
 	return err
 }
 
-func (db *SlackBoxDB) UnackConversation(id string, ackTs string) error {
+// Delete all but the latest depth acks for the conversation, or for every
+// conversation if conversationID is blank.  Returns how many were deleted.
+func trimAcks(db execer, teamID string, conversationID string, depth int) (int64, error) {
 	sql := `
       delete from acknowledgements
-      where conversation_id = ? and acknowledged_through_ts = ?
+      where rowid in (
+        select rowid from (
+          select
+            rowid,
+            row_number() over (
+              partition by team_id, conversation_id
+              order by acknowledged_through_ts desc
+            ) as recency
+          from
+            acknowledgements
+          where
+            ? = '' or (team_id = ? and conversation_id = ?)
+        )
+        where recency > ?
+      )
     `
-	_, err := db.db.Exec(sql, id, ackTs)
-	return err
+
+	result, err := db.Exec(sql, conversationID, teamID, conversationID, depth)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
 
-func ConnectDB(dbPath string) (*SlackBoxDB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// Trim the acks of every conversation, drop snoozes that have ended, and
+// reclaim the space they used, returning how many acks were deleted.
+func (db *SlackBoxDB) Compact() (int64, error) {
+	trimmed, err := trimAcks(db.db, "", "", db.ackHistory)
 	if err != nil {
-		return nil, err
+		return trimmed, err
 	}
 
-	err = initialize(db)
+	_, err = db.db.Exec("delete from snoozes where (team_id, conversation_id) not in (" + activeSnoozesSql + ")")
 	if err != nil {
-		return nil, err
+		return trimmed, err
 	}
 
-	return &SlackBoxDB{db}, nil
+	_, err = db.db.Exec("vacuum")
+	return trimmed, err
 }
 
-func (db *SlackBoxDB) GetUnackedConversations() ([]AcknowledgedConversation, error) {
+// Selects the workspace and id of the conversations that are snoozed right
+// now.
+const activeSnoozesSql = `
+        select
+          s.team_id, s.conversation_id
+        from
+          snoozes s join conversations sc
+          on s.team_id = sc.team_id and s.conversation_id = sc.id
+        where
+          (s.snoozed_until is null
+           and sc.latest_msg_ts <= s.snoozed_through_ts)
+          or s.snoozed_until > strftime('%s', 'now')
+      `
+
+type SnoozedConversation struct {
+	Conversation
+	// zero if the snooze lasts until a new message arrives
+	SnoozedUntil time.Time
+}
+
+// Hide the conversation from the unacked ones until the time until, or if
+// until is zero, until a message arrives after throughTs.  Replaces any
+// snooze it already has.
+func (db *SlackBoxDB) SnoozeConversation(teamID string, id string, throughTs string, until time.Time) error {
+	return snooze(db.db, teamID, id, throughTs, until)
+}
+
+func snooze(tx execer, teamID string, id string, throughTs string, until time.Time) error {
+	sql := `
+      insert into snoozes
+        (team_id, conversation_id, snoozed_through_ts, snoozed_until, snoozed_at)
+      values
+        (?,       ?,               ?,                  ?,             strftime('%s', 'now'))
+      on conflict (team_id, conversation_id)
+      do update set
+      snoozed_through_ts = excluded.snoozed_through_ts,
+      snoozed_until = excluded.snoozed_until,
+      snoozed_at = excluded.snoozed_at
+    `
+
+	var snoozedUntil interface{}
+	if !until.IsZero() {
+		snoozedUntil = until.Unix()
+	}
+
+	_, err := tx.Exec(sql, teamID, id, throughTs, snoozedUntil)
+	return err
+}
+
+func (db *SlackBoxDB) UnsnoozeConversation(teamID string, id string) error {
+	return unsnooze(db.db, teamID, id)
+}
+
+func unsnooze(tx execer, teamID string, id string) error {
+	_, err := tx.Exec("delete from snoozes where team_id = ? and conversation_id = ?", teamID, id)
+	return err
+}
+
+// The conversations that are snoozed right now, those waking soonest first
+// and those waiting on a new message last.
+func (db *SlackBoxDB) GetSnoozedConversations() ([]SnoozedConversation, error) {
+	sql := `
+      select
+        c.id, c.conversation_type, c.display_name, c.latest_msg_ts,
+        c.team_id, c.channel_id, c.thread_ts,
+        coalesce(s.snoozed_until, 0)
+      from
+        conversations c join snoozes s
+        on c.team_id = s.team_id and c.id = s.conversation_id
+      where
+        (c.team_id, c.id) in (` + activeSnoozesSql + `)
+      order by
+        s.snoozed_until is null,
+        s.snoozed_until asc,
+        c.latest_msg_ts desc,
+        c.id asc
+    `
+
+	conversations := make([]SnoozedConversation, 0)
+
+	rows, err := db.db.Query(sql)
+	if err != nil {
+		return conversations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c := SnoozedConversation{}
+		var snoozedUntil int64
+		err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.TeamID, &c.ChannelID, &c.ThreadTs, &snoozedUntil)
+		if err != nil {
+			return conversations, err
+		}
+
+		if snoozedUntil != 0 {
+			c.SnoozedUntil = time.Unix(snoozedUntil, 0)
+		}
+
+		conversations = append(conversations, c)
+	}
+
+	return conversations, rows.Err()
+}
+
+type RecentConversation struct {
+	AcknowledgedConversation
+	// zero for acks made before the time was recorded
+	AcknowledgedAt time.Time
+}
+
+// The limit conversations acked most recently, by their latest ack, whether
+// or not they've had new messages since.
+func (db *SlackBoxDB) GetRecentlyAckedConversations(limit int) ([]RecentConversation, error) {
 	sql := `
       with
 
       latest_acknowledgements as (
         select
+          team_id,
           conversation_id,
-          max(acknowledged_through_ts) as acknowledged_through_ts
+          acknowledged_through_ts,
+          acknowledged_at,
+          row_number() over (
+            partition by team_id, conversation_id
+            order by acknowledged_through_ts desc
+          ) as recency
         from
           acknowledgements
-        group by
-          conversation_id
       )
 
       select
         c.id, c.conversation_type, c.display_name, c.latest_msg_ts,
-        coalesce(a.acknowledged_through_ts, '')
+        c.team_id, c.channel_id, c.thread_ts,
+        a.acknowledged_through_ts, coalesce(a.acknowledged_at, 0)
       from
-        conversations c left outer join latest_acknowledgements a
-        on c.id = a.conversation_id
+        conversations c join latest_acknowledgements a
+        on c.team_id = a.team_id and c.id = a.conversation_id
       where
-        (c.latest_msg_ts > a.acknowledged_through_ts
-         or a.acknowledged_through_ts is null)
-        -- a blank latest_msg_ts would mean there had never
-        -- been a message in the conversation, so we don't
-        -- care about it
-        and c.latest_msg_ts <> ''
+        a.recency = 1
       order by
-        c.latest_msg_ts desc,
+        a.acknowledged_at is null,
+        a.acknowledged_at desc,
+        a.acknowledged_through_ts desc,
         c.id asc
+      limit ?
     `
 
-	conversations := make([]AcknowledgedConversation, 0)
+	conversations := make([]RecentConversation, 0)
 
-	rows, err := db.db.Query(sql)
+	rows, err := db.db.Query(sql, limit)
 	if err != nil {
 		return conversations, err
 	}
@@ -195,101 +493,238 @@ func (db *SlackBoxDB) GetUnackedConversations() ([]AcknowledgedConversation, err
 	defer rows.Close()
 
 	for rows.Next() {
-		c := AcknowledgedConversation{}
-		err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.AcknowledgedThroughTs)
+		c := RecentConversation{}
+		var acknowledgedAt int64
+		err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.TeamID, &c.ChannelID, &c.ThreadTs, &c.AcknowledgedThroughTs, &acknowledgedAt)
 		if err != nil {
 			return conversations, err
 		}
 
-		conversations = append(conversations, c)
-	}
+		if acknowledgedAt != 0 {
+			c.AcknowledgedAt = time.Unix(acknowledgedAt, 0)
+		}
 
-	if rows.Err() != nil {
-		return conversations, rows.Err()
+		conversations = append(conversations, c)
 	}
 
-	return conversations, nil
+	return conversations, rows.Err()
 }
 
-func checkSupportedVersion(db *sql.DB) error {
-	initVersionSql := `
-      create table if not exists version (
-        -- singleton should always be 1, regardless of the version,
-        -- and lets us maintain a single version row
-        singleton int not null primary key,
-        version int not null
-      );
+func (db *SlackBoxDB) UnackConversation(teamID string, id string, ackTs string) error {
+	return unack(db.db, teamID, id, ackTs)
+}
 
-      insert into version (singleton, version)
-      values (1, 1)
-      on conflict(singleton) do nothing;
+func unack(tx execer, teamID string, id string, ackTs string) error {
+	sql := `
+      delete from acknowledgements
+      where team_id = ? and conversation_id = ? and acknowledged_through_ts = ?
     `
+	_, err := tx.Exec(sql, teamID, id, ackTs)
+	return err
+}
 
-	_, err := db.Exec(initVersionSql)
+// Replace the stored directory entries for the workspace's users, marking
+// them as fetched at fetchedAt.
+func (db *SlackBoxDB) UpdateUsers(teamID string, users []User, fetchedAt time.Time) error {
+	sql := `
+      insert into users
+        (id, real_name, display_name, is_bot, deleted, team_id, fetched_at)
+      values
+        (?,  ?,         ?,            ?,      ?,       ?,       ?)
+      on conflict (team_id, id)
+      do update set
+      real_name = excluded.real_name,
+      display_name = excluded.display_name,
+      is_bot = excluded.is_bot,
+      deleted = excluded.deleted,
+      fetched_at = excluded.fetched_at
+    `
 
+	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	rows, err := db.Query("select version from version")
+	stmt, err := tx.Prepare(sql)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	defer rows.Close()
+	defer stmt.Close()
 
-	for rows.Next() {
-		var version int
-		err = rows.Scan(&version)
+	for _, user := range users {
+		_, err = stmt.Exec(user.ID, user.RealName, user.DisplayName, user.IsBot, user.Deleted, teamID, fetchedAt.Unix())
 		if err != nil {
+			tx.Rollback()
 			return err
 		}
+	}
+
+	return tx.Commit()
+}
 
-		if version > SupportedDBVersion {
-			return &unsupportedVersionError{actualVersion: version, supportedVersion: SupportedDBVersion}
+func (db *SlackBoxDB) GetUsers(teamID string) (map[string]User, error) {
+	users := make(map[string]User)
+
+	query := `
+    select
+      id, real_name, display_name, is_bot, deleted
+    from
+      users
+    where
+      team_id = ?
+    `
+	rows, err := db.db.Query(query, teamID)
+	if err != nil {
+		return users, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		u := User{}
+		err = rows.Scan(&u.ID, &u.RealName, &u.DisplayName, &u.IsBot, &u.Deleted)
+		if err != nil {
+			return users, err
 		}
+
+		users[u.ID] = u
 	}
 
-	err = rows.Err()
+	return users, rows.Err()
+}
+
+// When the workspace's user directory was last fetched, or the zero time if
+// it never has been.
+func (db *SlackBoxDB) GetUsersFetchedAt(teamID string) (time.Time, error) {
+	var fetchedAt sql.NullInt64
+
+	err := db.db.QueryRow("select max(fetched_at) from users where team_id = ?", teamID).Scan(&fetchedAt)
+	if err != nil || !fetchedAt.Valid {
+		return time.Time{}, err
+	}
+
+	return time.Unix(fetchedAt.Int64, 0), nil
+}
+
+func ConnectDB(dbPath string) (*SlackBoxDB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// sqlite doesn't like concurrent writers, and every connection to
+	// :memory: is a separate db, so serialize everything through a single
+	// connection.
+	db.SetMaxOpenConns(1)
+
+	err = migrate(db, dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SlackBoxDB{db, DefaultAckHistory}, nil
+}
+
+// Every conversation, with how far it's been acked, in the same order as
+// GetUnackedConversations.
+func (db *SlackBoxDB) GetAcknowledgedConversations() ([]AcknowledgedConversation, error) {
+	sql := `
+      select
+        c.id, c.conversation_type, c.display_name, c.latest_msg_ts,
+        c.team_id, c.channel_id, c.thread_ts,
+        coalesce(max(a.acknowledged_through_ts), '')
+      from
+        conversations c left outer join acknowledgements a
+        on c.team_id = a.team_id and c.id = a.conversation_id
+      group by
+        c.team_id, c.id
+      order by
+        c.latest_msg_ts desc,
+        c.id asc
+    `
+
+	conversations := make([]AcknowledgedConversation, 0)
+
+	rows, err := db.db.Query(sql)
+	if err != nil {
+		return conversations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c := AcknowledgedConversation{}
+		err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.TeamID, &c.ChannelID, &c.ThreadTs, &c.AcknowledgedThroughTs)
+		if err != nil {
+			return conversations, err
+		}
+
+		conversations = append(conversations, c)
+	}
+
+	return conversations, rows.Err()
 }
 
-// Initialize the db, creating the schema if necessary.  This function is
-// idempotent, and a db may be safely initialized multiple times..
-func initialize(db *sql.DB) error {
-	err := checkSupportedVersion(db)
+func (db *SlackBoxDB) GetUnackedConversations() ([]AcknowledgedConversation, error) {
+	sql := `
+      with
+
+      latest_acknowledgements as (
+        select
+          team_id,
+          conversation_id,
+          max(acknowledged_through_ts) as acknowledged_through_ts
+        from
+          acknowledgements
+        group by
+          team_id,
+          conversation_id
+      )
+
+      select
+        c.id, c.conversation_type, c.display_name, c.latest_msg_ts,
+        c.team_id, c.channel_id, c.thread_ts,
+        coalesce(a.acknowledged_through_ts, '')
+      from
+        conversations c left outer join latest_acknowledgements a
+        on c.team_id = a.team_id and c.id = a.conversation_id
+      where
+        (c.latest_msg_ts > a.acknowledged_through_ts
+         or a.acknowledged_through_ts is null)
+        -- a blank latest_msg_ts would mean there had never
+        -- been a message in the conversation, so we don't
+        -- care about it
+        and c.latest_msg_ts <> ''
+        and (c.team_id, c.id) not in (` + activeSnoozesSql + `)
+      order by
+        c.latest_msg_ts desc,
+        c.id asc
+    `
+
+	conversations := make([]AcknowledgedConversation, 0)
+
+	rows, err := db.db.Query(sql)
 	if err != nil {
-		return err
+		return conversations, err
 	}
 
-	schemaSql := `
-      -- the list of conversations we're tracking
-      create table if not exists conversations (
-        -- we use the im/channel id directly from the slack api, which is text
-        id text not null primary key,
-        -- either 'im' or 'channel'
-        conversation_type text not null,
-        display_name text not null,
-        -- the slack api uses text timestamps
-        latest_msg_ts text
-      );
-
-      create table if not exists acknowledgements (
-        conversation_id text not null,
-        -- slack ts indicating that conversation has been acknowledged up to 
-        -- and including this msg
-        acknowledged_through_ts text not null,
-        -- seconds since the epoch, db time when ack was made (*not* slack ts)
-        acknowledged_at int
-      );
-
-      create unique index if not exists ack_convo_idx on acknowledgements (
-        conversation_id, acknowledged_through_ts);
-	`
-	_, err = db.Exec(schemaSql)
-	return err
+	defer rows.Close()
+
+	for rows.Next() {
+		c := AcknowledgedConversation{}
+		err = rows.Scan(&c.ID, &c.ConversationType, &c.DisplayName, &c.LatestMsgTs, &c.TeamID, &c.ChannelID, &c.ThreadTs, &c.AcknowledgedThroughTs)
+		if err != nil {
+			return conversations, err
+		}
+
+		conversations = append(conversations, c)
+	}
+
+	if rows.Err() != nil {
+		return conversations, rows.Err()
+	}
+
+	return conversations, nil
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func memoryDB(t *testing.T) *SlackBoxDB {
@@ -65,8 +66,8 @@ func checkUpdate(t *testing.T, db *SlackBoxDB, c Conversation) {
 	}
 }
 
-func checkGet(t *testing.T, db *SlackBoxDB, id string) Conversation {
-	c, found, err := db.GetConversation(id)
+func checkGet(t *testing.T, db *SlackBoxDB, teamID string, id string) Conversation {
+	c, found, err := db.GetConversation(teamID, id)
 	if !found {
 		t.Fatalf("Couldn't find conversation post update")
 	}
@@ -81,7 +82,7 @@ func TestUpdateConversationNew(t *testing.T) {
 
 	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0000"}
 
-	_, found, err := db.GetConversation(c.ID)
+	_, found, err := db.GetConversation(c.TeamID, c.ID)
 	if found {
 		t.Fatal("Found conversation before it existed")
 	}
@@ -90,7 +91,7 @@ func TestUpdateConversationNew(t *testing.T) {
 	}
 
 	checkUpdate(t, db, c)
-	foundC := checkGet(t, db, c.ID)
+	foundC := checkGet(t, db, "", c.ID)
 
 	if !reflect.DeepEqual(c, foundC) {
 		t.Errorf("Expected to find conversation %s, found %s", c, foundC)
@@ -102,7 +103,7 @@ func TestUpdateConversationLater(t *testing.T) {
 
 	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0000"}
 	checkUpdate(t, db, c)
-	foundC := checkGet(t, db, c.ID)
+	foundC := checkGet(t, db, "", c.ID)
 
 	if !reflect.DeepEqual(c, foundC) {
 		t.Errorf("Expected to find conversation %s, found %s", c, foundC)
@@ -111,7 +112,7 @@ func TestUpdateConversationLater(t *testing.T) {
 	c2 := Conversation{ID: "someconvo", ConversationType: "channel", DisplayName: "display2", LatestMsgTs: "2.0000"}
 
 	checkUpdate(t, db, c2)
-	foundC = checkGet(t, db, c.ID)
+	foundC = checkGet(t, db, "", c.ID)
 
 	if c2.LatestMsgTs != foundC.LatestMsgTs {
 		t.Errorf("Didn't update timestamp %s %s", c2, foundC)
@@ -121,18 +122,18 @@ func TestUpdateConversationLater(t *testing.T) {
 		t.Errorf("didn't update displayname %s %s", c2, foundC)
 	}
 
-	if c2.ConversationType == foundC.ConversationType {
-		t.Errorf("mistakenly updated conversation type %s %s", c2, foundC)
+	if c2.ConversationType != foundC.ConversationType {
+		t.Errorf("didn't update conversation type %s %s", c2, foundC)
 	}
 }
 
-func testNoUpdate(t *testing.T, firstTs string, secondTs string) {
+func testNoTsUpdate(t *testing.T, firstTs string, secondTs string) {
 	db := memoryDB(t)
 
 	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: firstTs}
 
 	checkUpdate(t, db, c)
-	foundC := checkGet(t, db, c.ID)
+	foundC := checkGet(t, db, "", c.ID)
 
 	if !reflect.DeepEqual(c, foundC) {
 		t.Errorf("Expected to find conversation %s, found %s", c, foundC)
@@ -141,23 +142,40 @@ func testNoUpdate(t *testing.T, firstTs string, secondTs string) {
 	c2 := Conversation{ID: "someconvo", ConversationType: "channel", DisplayName: "display2", LatestMsgTs: secondTs}
 
 	checkUpdate(t, db, c2)
-	foundC = checkGet(t, db, c.ID)
-
-	if !reflect.DeepEqual(c, foundC) {
-		t.Errorf("Expected to find conversation %s, found %s", c, foundC)
-	}
-	if reflect.DeepEqual(c2, foundC) {
-		t.Errorf("Updated conversation mistakenly ts %s %s", c2, foundC)
+	foundC = checkGet(t, db, "", c.ID)
+
+	// the type and name are always slack's latest, but the ts never goes
+	// backwards
+	expected := c2
+	expected.LatestMsgTs = firstTs
+	if !reflect.DeepEqual(expected, foundC) {
+		t.Errorf("Expected to find conversation %s, found %s", expected, foundC)
 	}
 }
 
 func TestUpdateConversationEarlier(t *testing.T) {
-	testNoUpdate(t, "1.000", "0.0000")
+	testNoTsUpdate(t, "1.000", "0.0000")
 }
 
 func TestUpdateConversationSame(t *testing.T) {
 	// we shouldn't update on the same timestamp
-	testNoUpdate(t, "1.000", "1.000")
+	testNoTsUpdate(t, "1.000", "1.000")
+}
+
+func TestUpdateConversationRepairsMpims(t *testing.T) {
+	db := memoryDB(t)
+
+	// as group DMs were stored before they were named after their members
+	checkUpdate(t, db, Conversation{ID: "G1", ConversationType: "im", DisplayName: "G1", LatestMsgTs: "2.0000"})
+
+	// refreshing with nothing new still fixes them
+	c := Conversation{ID: "G1", ConversationType: "mpim", DisplayName: "alice, bob", LatestMsgTs: "2.0000"}
+	checkUpdate(t, db, c)
+	foundC := checkGet(t, db, "", c.ID)
+
+	if !reflect.DeepEqual(c, foundC) {
+		t.Errorf("Expected to find conversation %s, found %s", c, foundC)
+	}
 }
 
 func TestUpdateConversations(t *testing.T) {
@@ -172,13 +190,13 @@ func TestUpdateConversations(t *testing.T) {
 	if err != nil {
 		t.Errorf("UpdateConversations failed with error %s", err)
 	}
-	foundC := checkGet(t, db, c.ID)
+	foundC := checkGet(t, db, "", c.ID)
 
 	if !reflect.DeepEqual(c, foundC) {
 		t.Errorf("Expected to find conversation %s, found %s", c, foundC)
 	}
 
-	foundC2 := checkGet(t, db, c2.ID)
+	foundC2 := checkGet(t, db, "", c2.ID)
 
 	if !reflect.DeepEqual(c2, foundC2) {
 		t.Errorf("Expected to find conversation %s, found %s", c2, foundC2)
@@ -199,13 +217,13 @@ func TestAckingConversation(t *testing.T) {
 	checkUpdate(t, db, c2)
 	checkUnacked(t, db, []Conversation{c, c2})
 
-	checkAck(t, db, c.ID, ts1)
+	checkAck(t, db, "", c.ID, ts1)
 	checkUnacked(t, db, []Conversation{c2})
 
-	checkAck(t, db, c.ID, ts2)
+	checkAck(t, db, "", c.ID, ts2)
 	checkUnacked(t, db, []Conversation{c2})
 
-	checkAck(t, db, c2.ID, ts1)
+	checkAck(t, db, "", c2.ID, ts1)
 	checkUnacked(t, db, []Conversation{})
 
 	c2.LatestMsgTs = ts2
@@ -227,32 +245,32 @@ func TestUnackingConversation(t *testing.T) {
 	checkUpdate(t, db, c2)
 	checkUnacked(t, db, []Conversation{c, c2})
 
-	checkAck(t, db, c.ID, ts1)
+	checkAck(t, db, "", c.ID, ts1)
 	checkUnacked(t, db, []Conversation{c2})
 
-	checkUnack(t, db, c.ID, ts1)
+	checkUnack(t, db, "", c.ID, ts1)
 	checkUnacked(t, db, []Conversation{c, c2})
 
-	checkAck(t, db, c.ID, ts1)
+	checkAck(t, db, "", c.ID, ts1)
 	checkUnacked(t, db, []Conversation{c2})
-	checkUnack(t, db, c.ID, ts2)
+	checkUnack(t, db, "", c.ID, ts2)
 	checkUnacked(t, db, []Conversation{c2})
 
-	checkAck(t, db, c.ID, ts2)
+	checkAck(t, db, "", c.ID, ts2)
 	checkUnacked(t, db, []Conversation{c2})
-	checkUnack(t, db, c.ID, ts1)
+	checkUnack(t, db, "", c.ID, ts1)
 	checkUnacked(t, db, []Conversation{c2})
 }
 
-func checkAck(t *testing.T, db *SlackBoxDB, id string, ts string) {
-	err := db.AckConversation(id, ts)
+func checkAck(t *testing.T, db *SlackBoxDB, teamID string, id string, ts string) {
+	err := db.AckConversation(teamID, id, ts)
 	if err != nil {
 		t.Errorf("AckConversation failed with error %s", err)
 	}
 }
 
-func checkUnack(t *testing.T, db *SlackBoxDB, id string, ts string) {
-	err := db.UnackConversation(id, ts)
+func checkUnack(t *testing.T, db *SlackBoxDB, teamID string, id string, ts string) {
+	err := db.UnackConversation(teamID, id, ts)
 	if err != nil {
 		t.Errorf("UnackConversation failed with error %s", err)
 	}
@@ -289,4 +307,460 @@ func checkConversations(t *testing.T, expected Conversation, actual Acknowledged
 	if expected.LatestMsgTs != actual.LatestMsgTs {
 		t.Errorf("Excpected latestmsgts %s, got %s", expected.LatestMsgTs, actual.LatestMsgTs)
 	}
+
+	if expected.ChannelID != actual.ChannelID || expected.ThreadTs != actual.ThreadTs {
+		t.Errorf("Expected thread %s/%s, got %s/%s", expected.ChannelID, expected.ThreadTs, actual.ChannelID, actual.ThreadTs)
+	}
+
+	if expected.TeamID != actual.TeamID {
+		t.Errorf("Expected team %s, got %s", expected.TeamID, actual.TeamID)
+	}
+}
+
+func TestGetLatestMsgTimestamps(t *testing.T) {
+	db := memoryDB(t)
+
+	timestamps, err := db.GetLatestMsgTimestamps("T1")
+	if err != nil {
+		t.Fatalf("GetLatestMsgTimestamps failed with error %s", err)
+	}
+	if len(timestamps) != 0 {
+		t.Errorf("Expected no timestamps, got %v", timestamps)
+	}
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0", TeamID: "T1"}
+	c2 := Conversation{ID: "someconvo2", ConversationType: "im", DisplayName: "display2", LatestMsgTs: "", TeamID: "T1"}
+	otherTeam := Conversation{ID: "otherconvo", ConversationType: "im", DisplayName: "other", LatestMsgTs: "2.0", TeamID: "T2"}
+	checkUpdate(t, db, c)
+	checkUpdate(t, db, c2)
+	checkUpdate(t, db, otherTeam)
+
+	timestamps, err = db.GetLatestMsgTimestamps("T1")
+	if err != nil {
+		t.Fatalf("GetLatestMsgTimestamps failed with error %s", err)
+	}
+
+	expected := map[string]string{c.ID: c.LatestMsgTs, c2.ID: c2.LatestMsgTs}
+	if !reflect.DeepEqual(expected, timestamps) {
+		t.Errorf("Expected timestamps %v, got %v", expected, timestamps)
+	}
+}
+
+func TestGetThreads(t *testing.T) {
+	db := memoryDB(t)
+
+	parent := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "5.0", TeamID: "T1"}
+	old := newThread(parent, "1.0")
+	old.DisplayName = "display › old"
+	old.LatestMsgTs = "2.0"
+	recent := newThread(parent, "3.0")
+	recent.DisplayName = "display › recent"
+	recent.LatestMsgTs = "4.0"
+	otherParent := Conversation{ID: "otherconvo", ConversationType: "im", DisplayName: "other", LatestMsgTs: "5.0", TeamID: "T2"}
+	otherTeam := newThread(otherParent, "3.0")
+	otherTeam.DisplayName = "other › recent"
+	otherTeam.LatestMsgTs = "4.0"
+	checkUpdate(t, db, parent)
+	checkUpdate(t, db, old)
+	checkUpdate(t, db, recent)
+	checkUpdate(t, db, otherTeam)
+
+	threads, err := db.GetThreads("T1", "3.0")
+	if err != nil {
+		t.Fatalf("GetThreads failed with error %s", err)
+	}
+
+	expected := []Conversation{recent}
+	if !reflect.DeepEqual(expected, threads) {
+		t.Errorf("Expected threads %v, got %v", expected, threads)
+	}
+
+	found := checkGet(t, db, recent.TeamID, recent.ID)
+	if found != recent {
+		t.Errorf("Expected thread %v, got %v", recent, found)
+	}
+	if found.SlackChannelID() != parent.ID {
+		t.Errorf("Expected thread in %s, got %s", parent.ID, found.SlackChannelID())
+	}
+
+	checkUnacked(t, db, []Conversation{parent, otherTeam, recent, old})
+}
+
+func TestGetLatestMentionTs(t *testing.T) {
+	db := memoryDB(t)
+
+	checkLatest := func(expected string) {
+		latest, err := db.GetLatestMentionTs("T1")
+		if err != nil {
+			t.Fatalf("GetLatestMentionTs failed with error %s", err)
+		}
+		if latest != expected {
+			t.Errorf("Expected latest mention ts %s, got %s", expected, latest)
+		}
+	}
+
+	checkLatest("")
+
+	checkUpdate(t, db, Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "9.0"})
+	checkLatest("")
+
+	older := Conversation{ID: mentionID("C1", "2.0"), ConversationType: "mention", DisplayName: "older", LatestMsgTs: "2.0", TeamID: "T1", ChannelID: "C1"}
+	newer := Conversation{ID: mentionID("C2", "3.0"), ConversationType: "mention", DisplayName: "newer", LatestMsgTs: "3.0", TeamID: "T1", ChannelID: "C2", ThreadTs: "1.0"}
+	otherTeam := Conversation{ID: mentionID("C3", "4.0"), ConversationType: "mention", DisplayName: "other", LatestMsgTs: "4.0", TeamID: "T2", ChannelID: "C3"}
+	checkUpdate(t, db, newer)
+	checkUpdate(t, db, older)
+	checkUpdate(t, db, otherTeam)
+	checkLatest("3.0")
+
+	// each mention is acked on its own
+	checkAck(t, db, newer.TeamID, newer.ID, newer.LatestMsgTs)
+	checkLatest("3.0")
+	checkUnacked(t, db, []Conversation{Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "9.0"}, otherTeam, older})
+}
+
+func checkSnooze(t *testing.T, db *SlackBoxDB, teamID string, id string, ts string, until time.Time) {
+	err := db.SnoozeConversation(teamID, id, ts, until)
+	if err != nil {
+		t.Errorf("Failed snoozing conversation %s", err)
+	}
+}
+
+func checkSnoozed(t *testing.T, db *SlackBoxDB, expected []string) {
+	snoozed, err := db.GetSnoozedConversations()
+	if err != nil {
+		t.Fatalf("GetSnoozedConversations failed with error %s", err)
+	}
+
+	ids := make([]string, 0, len(snoozed))
+	for _, sc := range snoozed {
+		ids = append(ids, sc.ID)
+	}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected snoozed %v, got %v", expected, ids)
+	}
+}
+
+func TestSnoozeConversation(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	c2 := Conversation{ID: "someconvo2", ConversationType: "im", DisplayName: "display2", LatestMsgTs: "2.0"}
+	c3 := Conversation{ID: "someconvo3", ConversationType: "im", DisplayName: "display3", LatestMsgTs: "3.0"}
+	checkUpdate(t, db, c)
+	checkUpdate(t, db, c2)
+	checkUpdate(t, db, c3)
+
+	checkSnooze(t, db, "", c.ID, c.LatestMsgTs, time.Now().Add(time.Hour))
+	checkSnooze(t, db, "", c2.ID, c2.LatestMsgTs, time.Time{})
+	checkSnooze(t, db, "", c3.ID, c3.LatestMsgTs, time.Now().Add(-time.Hour))
+	checkUnacked(t, db, []Conversation{c3})
+	checkSnoozed(t, db, []string{c.ID, c2.ID})
+
+	// a new message wakes a snooze without a time, but not one with
+	c.LatestMsgTs = "4.0"
+	c2.LatestMsgTs = "5.0"
+	checkUpdate(t, db, c)
+	checkUpdate(t, db, c2)
+	checkUnacked(t, db, []Conversation{c2, c3})
+	checkSnoozed(t, db, []string{c.ID})
+
+	err := db.UnsnoozeConversation(c.TeamID, c.ID)
+	if err != nil {
+		t.Errorf("Failed unsnoozing conversation %s", err)
+	}
+	checkUnacked(t, db, []Conversation{c2, c, c3})
+	checkSnoozed(t, db, []string{})
+
+	// snoozing again replaces the old snooze
+	checkSnooze(t, db, "", c3.ID, c3.LatestMsgTs, time.Time{})
+	checkSnoozed(t, db, []string{c3.ID})
+
+	_, err = db.Compact()
+	if err != nil {
+		t.Fatalf("Compact failed with error %s", err)
+	}
+
+	var count int
+	err = db.db.QueryRow("select count(*) from snoozes").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed counting snoozes %s", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected compact to leave 1 snooze, got %d", count)
+	}
+}
+
+func TestGetRecentlyAckedConversations(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "3.0"}
+	c2 := Conversation{ID: "someconvo2", ConversationType: "im", DisplayName: "display2", LatestMsgTs: "2.0"}
+	c3 := Conversation{ID: "someconvo3", ConversationType: "im", DisplayName: "display3", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+	checkUpdate(t, db, c2)
+	checkUpdate(t, db, c3)
+
+	checkAck(t, db, "", c.ID, "1.0")
+	checkAck(t, db, "", c.ID, "2.0")
+	checkAck(t, db, "", c2.ID, "2.0")
+
+	// c's latest ack was made before c2's, despite its ts
+	_, err := db.db.Exec("update acknowledgements set acknowledged_at = 100 where conversation_id = ?", c.ID)
+	if err != nil {
+		t.Fatalf("Failed setting ack times %s", err)
+	}
+	_, err = db.db.Exec("update acknowledgements set acknowledged_at = 200 where conversation_id = ?", c2.ID)
+	if err != nil {
+		t.Fatalf("Failed setting ack times %s", err)
+	}
+
+	recent, err := db.GetRecentlyAckedConversations(10)
+	if err != nil {
+		t.Fatalf("GetRecentlyAckedConversations failed with error %s", err)
+	}
+
+	if len(recent) != 2 {
+		t.Fatalf("Expected 2 recent conversations, got %d", len(recent))
+	}
+
+	checkConversations(t, c2, recent[0].AcknowledgedConversation)
+	checkConversations(t, c, recent[1].AcknowledgedConversation)
+
+	if recent[1].AcknowledgedThroughTs != "2.0" {
+		t.Errorf("Expected recent ack through 2.0, got %s", recent[1].AcknowledgedThroughTs)
+	}
+	if !recent[0].AcknowledgedAt.Equal(time.Unix(200, 0)) {
+		t.Errorf("Expected ack at %s, got %s", time.Unix(200, 0), recent[0].AcknowledgedAt)
+	}
+
+	recent, err = db.GetRecentlyAckedConversations(1)
+	if err != nil {
+		t.Fatalf("GetRecentlyAckedConversations failed with error %s", err)
+	}
+	if len(recent) != 1 || recent[0].ID != c2.ID {
+		t.Errorf("Expected only %s, got %v", c2.ID, recent)
+	}
+}
+
+func TestUpdateUsers(t *testing.T) {
+	db := memoryDB(t)
+
+	fetchedAt, err := db.GetUsersFetchedAt("T1")
+	if err != nil {
+		t.Fatalf("GetUsersFetchedAt failed with error %s", err)
+	}
+	if !fetchedAt.IsZero() {
+		t.Errorf("Expected users never to have been fetched, got %s", fetchedAt)
+	}
+
+	u := User{ID: "U1", RealName: "Alice Real", DisplayName: "alice"}
+	u2 := User{ID: "U2", RealName: "Bot", DisplayName: "bot", IsBot: true, Deleted: true}
+
+	firstFetch := time.Unix(1000, 0)
+	err = db.UpdateUsers("T1", []User{u, u2}, firstFetch)
+	if err != nil {
+		t.Fatalf("UpdateUsers failed with error %s", err)
+	}
+
+	u3 := User{ID: "U3", RealName: "Carol"}
+	err = db.UpdateUsers("T2", []User{u3}, time.Unix(3000, 0))
+	if err != nil {
+		t.Fatalf("UpdateUsers failed with error %s", err)
+	}
+
+	users, err := db.GetUsers("T1")
+	if err != nil {
+		t.Fatalf("GetUsers failed with error %s", err)
+	}
+
+	expected := map[string]User{u.ID: u, u2.ID: u2}
+	if !reflect.DeepEqual(expected, users) {
+		t.Errorf("Expected users %v, got %v", expected, users)
+	}
+
+	u.RealName = "Alice Renamed"
+	secondFetch := time.Unix(2000, 0)
+	err = db.UpdateUsers("T1", []User{u}, secondFetch)
+	if err != nil {
+		t.Fatalf("UpdateUsers failed with error %s", err)
+	}
+
+	users, err = db.GetUsers("T1")
+	if err != nil {
+		t.Fatalf("GetUsers failed with error %s", err)
+	}
+
+	if users[u.ID].RealName != u.RealName {
+		t.Errorf("Expected real name %s, got %s", u.RealName, users[u.ID].RealName)
+	}
+
+	// each workspace's directory is fetched on its own
+	fetchedAt, err = db.GetUsersFetchedAt("T1")
+	if err != nil {
+		t.Fatalf("GetUsersFetchedAt failed with error %s", err)
+	}
+	if !fetchedAt.Equal(secondFetch) {
+		t.Errorf("Expected users fetched at %s, got %s", secondFetch, fetchedAt)
+	}
+}
+
+// Slack Connect channels keep their id in each workspace they're shared with,
+// and Enterprise Grid users theirs in each workspace of the grid.
+func TestSharedIDsStayApart(t *testing.T) {
+	db := memoryDB(t)
+
+	ours := Conversation{ID: "C1", ConversationType: "channel", DisplayName: "#shared", LatestMsgTs: "2.0", TeamID: "T1"}
+	theirs := Conversation{ID: "C1", ConversationType: "channel", DisplayName: "#partners", LatestMsgTs: "3.0", TeamID: "T2"}
+	checkUpdate(t, db, ours)
+	checkUpdate(t, db, theirs)
+
+	if found := checkGet(t, db, ours.TeamID, ours.ID); found != ours {
+		t.Errorf("Expected conversation %v, got %v", ours, found)
+	}
+	if found := checkGet(t, db, theirs.TeamID, theirs.ID); found != theirs {
+		t.Errorf("Expected conversation %v, got %v", theirs, found)
+	}
+
+	checkAck(t, db, ours.TeamID, ours.ID, ours.LatestMsgTs)
+	checkUnacked(t, db, []Conversation{theirs})
+
+	checkSnooze(t, db, theirs.TeamID, theirs.ID, theirs.LatestMsgTs, time.Time{})
+	checkUnacked(t, db, []Conversation{})
+	checkSnoozed(t, db, []string{"C1"})
+
+	for _, teamID := range []string{"T1", "T2", "T1"} {
+		err := db.UpdateUsers(teamID, []User{{ID: "U1", RealName: "Alice"}}, time.Unix(1000, 0))
+		if err != nil {
+			t.Fatalf("UpdateUsers failed with error %s", err)
+		}
+	}
+	for _, teamID := range []string{"T1", "T2"} {
+		users, err := db.GetUsers(teamID)
+		if err != nil {
+			t.Fatalf("GetUsers failed with error %s", err)
+		}
+		if users["U1"].RealName != "Alice" {
+			t.Errorf("Expected U1 in %s, got %v", teamID, users)
+		}
+	}
+}
+
+func TestClaimUnowned(t *testing.T) {
+	db := memoryDB(t)
+
+	legacy := Conversation{ID: "D1", ConversationType: "im", DisplayName: "alice", LatestMsgTs: "1.0"}
+	other := Conversation{ID: "D2", ConversationType: "im", DisplayName: "bob", LatestMsgTs: "2.0", TeamID: "T2"}
+	checkUpdate(t, db, legacy)
+	checkUpdate(t, db, other)
+	err := db.UpdateUsers("", []User{{ID: "U1", RealName: "Alice"}}, time.Unix(1000, 0))
+	if err != nil {
+		t.Fatalf("UpdateUsers failed with error %s", err)
+	}
+
+	err = db.ClaimUnowned("T1")
+	if err != nil {
+		t.Fatalf("ClaimUnowned failed with error %s", err)
+	}
+
+	legacy.TeamID = "T1"
+	if found := checkGet(t, db, legacy.TeamID, legacy.ID); found != legacy {
+		t.Errorf("Expected conversation %v, got %v", legacy, found)
+	}
+	if found := checkGet(t, db, other.TeamID, other.ID); found != other {
+		t.Errorf("Expected conversation %v, got %v", other, found)
+	}
+
+	users, err := db.GetUsers("T1")
+	if err != nil {
+		t.Fatalf("GetUsers failed with error %s", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("Expected the stored user claimed, got %v", users)
+	}
+}
+
+func countAcks(t *testing.T, db *SlackBoxDB, id string) int {
+	var count int
+	err := db.db.QueryRow("select count(*) from acknowledgements where conversation_id = ?", id).Scan(&count)
+	if err != nil {
+		t.Fatalf("Could not count acks %s", err)
+	}
+	return count
+}
+
+func TestAckRecordsTime(t *testing.T) {
+	db := memoryDB(t)
+
+	before := time.Now().Unix()
+	checkAck(t, db, "", "someconvo", "1.0")
+	after := time.Now().Unix()
+
+	var acknowledgedAt int64
+	err := db.db.QueryRow("select acknowledged_at from acknowledgements").Scan(&acknowledgedAt)
+	if err != nil {
+		t.Fatalf("Could not read acknowledged_at %s", err)
+	}
+
+	if acknowledgedAt < before || acknowledgedAt > after {
+		t.Errorf("Expected acknowledged_at between %d and %d, got %d", before, after, acknowledgedAt)
+	}
+}
+
+func TestAckTrimsHistory(t *testing.T) {
+	db := memoryDB(t)
+	db.SetAckHistory(2)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "4.0"}
+	c2 := Conversation{ID: "someconvo2", ConversationType: "im", DisplayName: "display2", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+	checkUpdate(t, db, c2)
+	checkAck(t, db, "", c2.ID, "1.0")
+
+	for _, ts := range []string{"1.0", "2.0", "3.0"} {
+		checkAck(t, db, "", c.ID, ts)
+	}
+
+	if countAcks(t, db, c.ID) != 2 {
+		t.Errorf("Expected 2 acks kept, got %d", countAcks(t, db, c.ID))
+	}
+
+	// other conversations' acks are untouched
+	if countAcks(t, db, c2.ID) != 1 {
+		t.Errorf("Expected 1 ack for other conversation, got %d", countAcks(t, db, c2.ID))
+	}
+
+	// unacking still steps back to the previous ack that was kept
+	checkUnack(t, db, "", c.ID, "3.0")
+	unacked, err := db.GetUnackedConversations()
+	if err != nil {
+		t.Fatalf("GetUnackedConversations failed with error %s", err)
+	}
+	if len(unacked) != 1 || unacked[0].AcknowledgedThroughTs != "2.0" {
+		t.Errorf("Expected conversation acked through 2.0 after unack, got %v", unacked)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db := memoryDB(t)
+
+	// acks made before trimming existed, e.g. by an older slackbox
+	db.SetAckHistory(10)
+	for _, ts := range []string{"1.0", "2.0", "3.0"} {
+		checkAck(t, db, "", "someconvo", ts)
+		checkAck(t, db, "", "someconvo2", ts)
+	}
+
+	db.SetAckHistory(1)
+	trimmed, err := db.Compact()
+	if err != nil {
+		t.Fatalf("Compact failed with error %s", err)
+	}
+
+	if trimmed != 4 {
+		t.Errorf("Expected 4 acks trimmed, got %d", trimmed)
+	}
+
+	if countAcks(t, db, "someconvo") != 1 || countAcks(t, db, "someconvo2") != 1 {
+		t.Errorf("Expected a single ack left per conversation")
+	}
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/slack-go/slack"
+)
+
+// Errors connecting to RTM that retrying won't fix, with what they mean.
+// slack-go retries everything but a rejected token, forever.
+var fatalConnectionErrors = map[string]string{
+	"not_allowed_token_type": "the token can't use RTM, which only classic slack apps' tokens can, so turn realtime off or use such a token",
+	"missing_scope":          "the token is missing the scope RTM needs",
+	"no_permission":          "the workspace doesn't let the token use RTM",
+	"token_revoked":          "the token has been revoked",
+	"token_expired":          "the token has expired",
+}
+
+// What to report for a failure to connect the stream, and whether it's one
+// retrying won't fix.
+func connectionError(ev *slack.ConnectionErrorEvent) (error, bool) {
+	if ev.ErrorObj != nil {
+		if reason, found := fatalConnectionErrors[ev.ErrorObj.Error()]; found {
+			return fmt.Errorf("Real-time updates are unavailable: %s", reason), true
+		}
+	}
+	return fmt.Errorf("Real-time updates couldn't connect, retrying in %s: %s", ev.Backoff, ev.ErrorObj), false
+}
+
+// Keeps the db current from slack's RTM event stream, so that new messages
+// show up in the inbox without a manual refresh.
+type EventStream struct {
+	api      *SlackBoxAPI
+	db       *SlackBoxDB
+	rtm      *slack.RTM
+	done     chan struct{}
+	onUpdate func(Conversation)
+	onError  func(error)
+}
+
+// Create a stream that records new messages in db and then calls onUpdate
+// with the updated conversation.  Errors handling events or connecting are
+// passed to onError, and the stream keeps running; an error authenticating
+// the stream, or connecting in a way retrying won't fix, stops it.
+func (api *SlackBoxAPI) NewEventStream(db *SlackBoxDB, onUpdate func(Conversation), onError func(error)) *EventStream {
+	return &EventStream{
+		api:      api,
+		db:       db,
+		rtm:      api.client.NewRTM(),
+		done:     make(chan struct{}),
+		onUpdate: onUpdate,
+		onError:  onError,
+	}
+}
+
+// Connect and process events until Stop is called or the stream can't
+// connect.  Blocks, so generally should be run in its own goroutine.
+func (s *EventStream) Run() {
+	go s.rtm.ManageConnection()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case event := <-s.rtm.IncomingEvents:
+			switch ev := event.Data.(type) {
+			case *slack.MessageEvent:
+				conversation, updated, err := s.handleMessage(ev)
+				if err != nil {
+					s.onError(err)
+				} else if updated {
+					s.onUpdate(conversation)
+				}
+			case *slack.ConnectionErrorEvent:
+				err, fatal := connectionError(ev)
+				s.onError(err)
+				if fatal {
+					// slack-go would otherwise keep retrying
+					s.rtm.Disconnect()
+					return
+				}
+			case *slack.InvalidAuthEvent:
+				s.onError(errors.New("Real-time updates are unavailable: the token was rejected by the event stream"))
+				return
+			}
+		}
+	}
+}
+
+func (s *EventStream) Stop() {
+	close(s.done)
+	s.rtm.Disconnect()
+}
+
+func isNewMessage(ev *slack.MessageEvent) bool {
+	// edits, deletions and the like come through as hidden messages, and
+	// don't mean there's anything new to read.
+	return !ev.Hidden && ev.Channel != "" && ev.Timestamp != ""
+}
+
+// A reply in a thread, as opposed to a message in the conversation itself.
+// Replies also sent to the channel count as the latter.
+func isThreadReply(ev *slack.MessageEvent) bool {
+	return ev.ThreadTimestamp != "" && ev.ThreadTimestamp != ev.Timestamp && ev.SubType != "thread_broadcast"
+}
+
+func (s *EventStream) handleMessage(ev *slack.MessageEvent) (Conversation, bool, error) {
+	if !isNewMessage(ev) {
+		return Conversation{}, false, nil
+	}
+
+	if isThreadReply(ev) {
+		return s.handleThreadReply(ev)
+	}
+
+	conversation, found, err := s.db.GetConversation(s.api.TeamID(), ev.Channel)
+	if err != nil {
+		return conversation, false, err
+	}
+
+	if !found {
+		var tracked bool
+		conversation, tracked, err = s.api.FetchConversation(context.Background(), ev.Channel)
+		if err != nil || !tracked {
+			return conversation, false, err
+		}
+	}
+
+	if ev.Timestamp > conversation.LatestMsgTs {
+		conversation.LatestMsgTs = ev.Timestamp
+	}
+
+	err = s.db.UpdateConversation(conversation)
+	if err != nil {
+		return conversation, false, err
+	}
+
+	return conversation, true, nil
+}
+
+// Bump a thread we follow, or start following a thread in a tracked
+// conversation if it's one we would have picked up on refresh, or we're
+// part of it.
+func (s *EventStream) handleThreadReply(ev *slack.MessageEvent) (Conversation, bool, error) {
+	thread, found, err := s.db.GetConversation(s.api.TeamID(), threadID(ev.Channel, ev.ThreadTimestamp))
+	if err != nil {
+		return thread, false, err
+	}
+
+	if !found {
+		if !s.api.threads {
+			return thread, false, nil
+		}
+
+		parent, tracked, err := s.db.GetConversation(s.api.TeamID(), ev.Channel)
+		if err != nil || !tracked {
+			return thread, false, err
+		}
+
+		mine := ev.User == s.api.userID || ev.ParentUserId == s.api.userID
+		if !mine && parent.ConversationType == "channel" {
+			return thread, false, nil
+		}
+
+		thread, err = s.api.FetchThread(context.Background(), newThread(parent, ev.ThreadTimestamp), parent.DisplayName)
+		if err != nil {
+			return thread, false, err
+		}
+	}
+
+	if ev.Timestamp > thread.LatestMsgTs {
+		thread.LatestMsgTs = ev.Timestamp
+	}
+
+	err = s.db.UpdateConversation(thread)
+	if err != nil {
+		return thread, false, err
+	}
+
+	return thread, true, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slacktest"
+)
+
+// slacktest sends direct messages to the bot in this channel
+const testDMChannel = "D024BE91L"
+
+func startTestStream(t *testing.T, db *SlackBoxDB) (*slacktest.Server, chan Conversation, *EventStream) {
+	server := slacktest.NewTestServer()
+	go server.Start()
+
+	client := slack.New("ABCDEFG", slack.OptionAPIURL(server.GetAPIURL()))
+	api := &SlackBoxAPI{client: client, limiter: newRateLimiter(nil)}
+
+	updates := make(chan Conversation, 1)
+	stream := api.NewEventStream(
+		db,
+		func(c Conversation) {
+			updates <- c
+		},
+		func(err error) {
+			t.Errorf("Event stream error %s", err)
+		})
+	go stream.Run()
+
+	return server, updates, stream
+}
+
+func waitForUpdate(t *testing.T, updates chan Conversation) Conversation {
+	select {
+	case c := <-updates:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("Did not get an update from the event stream in time")
+	}
+	return Conversation{}
+}
+
+func TestEventStreamUpdatesKnownConversation(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: testDMChannel, ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+	checkAck(t, db, "", c.ID, c.LatestMsgTs)
+	checkUnacked(t, db, []Conversation{})
+
+	server, updates, stream := startTestStream(t, db)
+	defer server.Stop()
+	defer stream.Stop()
+
+	server.SendDirectMessageToBot("some text")
+	updated := waitForUpdate(t, updates)
+
+	if updated.ID != c.ID {
+		t.Errorf("Expected update to %s, got %s", c.ID, updated.ID)
+	}
+
+	if updated.LatestMsgTs <= c.LatestMsgTs {
+		t.Errorf("Expected latest ts after %s, got %s", c.LatestMsgTs, updated.LatestMsgTs)
+	}
+
+	foundC := checkGet(t, db, "", c.ID)
+	if foundC.LatestMsgTs != updated.LatestMsgTs {
+		t.Errorf("Expected stored latest ts %s, got %s", updated.LatestMsgTs, foundC.LatestMsgTs)
+	}
+
+	checkUnacked(t, db, []Conversation{foundC})
+}
+
+func TestEventStreamIgnoresHiddenMessages(t *testing.T) {
+	ev := &slack.MessageEvent{}
+	ev.Channel = testDMChannel
+	ev.Timestamp = "2.0"
+	ev.Hidden = true
+
+	if isNewMessage(ev) {
+		t.Error("Hidden message treated as new")
+	}
+
+	ev.Hidden = false
+	if !isNewMessage(ev) {
+		t.Error("Visible message not treated as new")
+	}
+}
+
+func TestEventStreamStopsWhenRTMIsNotAllowed(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("rtm.connect", func(url.Values) interface{} {
+		return map[string]interface{}{"ok": false, "error": "not_allowed_token_type"}
+	})
+
+	errs := make(chan error, 10)
+	stream := s.api(APIOptions{}).NewEventStream(memoryDB(t), func(Conversation) {}, func(err error) {
+		errs <- err
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		stream.Run()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		stream.Stop()
+		t.Fatal("Event stream kept retrying a token that can't use RTM")
+	}
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "can't use RTM") {
+			t.Errorf("Expected an error saying the token can't use RTM, got %s", err)
+		}
+	default:
+		t.Error("Expected the failure to connect reported")
+	}
+}
+
+func TestConnectionErrors(t *testing.T) {
+	err, fatal := connectionError(&slack.ConnectionErrorEvent{ErrorObj: errors.New("not_allowed_token_type"), Backoff: time.Second})
+	if !fatal || !strings.Contains(err.Error(), "can't use RTM") {
+		t.Errorf("Expected not_allowed_token_type to stop the stream, got %v %s", fatal, err)
+	}
+
+	err, fatal = connectionError(&slack.ConnectionErrorEvent{ErrorObj: errors.New("connection refused"), Backoff: time.Second})
+	if fatal || !strings.Contains(err.Error(), "retrying in 1s: connection refused") {
+		t.Errorf("Expected a dropped connection to be retried, got %v %s", fatal, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// An in-memory SlackAPI.  Each fetch returns what's been set up, or err if
+// it's set.
+type fakeSlack struct {
+	teamID        string
+	teamName      string
+	threads       bool
+	users         []User
+	conversations []Conversation
+	// what FetchThread(s) bring the threads with these ids up to
+	threadUpdates map[string]Conversation
+	mentions      []Conversation
+	messages      map[string][]Message
+	err           error
+
+	// what the fake was asked for, and told
+	usersFetched       int
+	userNames          map[string]User
+	fetchedSince       map[string]string
+	fetchedThreads     []string
+	fetchedMentionsFor string
+	posted             []Message
+}
+
+func newFakeSlack() *fakeSlack {
+	return &fakeSlack{
+		teamID:        "TFAKE",
+		teamName:      "fake team",
+		threads:       true,
+		threadUpdates: make(map[string]Conversation),
+		messages:      make(map[string][]Message),
+		userNames:     make(map[string]User),
+	}
+}
+
+func (f *fakeSlack) TeamID() string {
+	return f.teamID
+}
+
+func (f *fakeSlack) TeamName() string {
+	return f.teamName
+}
+
+func (f *fakeSlack) OnProgress(progress func(string)) {
+}
+
+func (f *fakeSlack) FollowsThreads() bool {
+	return f.threads
+}
+
+func (f *fakeSlack) FetchUsers(ctx context.Context) ([]User, error) {
+	f.usersFetched++
+	return f.users, f.err
+}
+
+func (f *fakeSlack) SetUsers(users map[string]User) {
+	f.userNames = users
+}
+
+func (f *fakeSlack) UserName(id string) string {
+	if user, found := f.userNames[id]; found {
+		return user.Name()
+	}
+	return id
+}
+
+func (f *fakeSlack) FetchConversations(ctx context.Context, latestMsgTimestamps map[string]string) ([]Conversation, error) {
+	f.fetchedSince = latestMsgTimestamps
+	return f.conversations, f.err
+}
+
+func (f *fakeSlack) FetchThread(ctx context.Context, thread Conversation, parentName string) (Conversation, error) {
+	f.fetchedThreads = append(f.fetchedThreads, thread.ID)
+	if update, found := f.threadUpdates[thread.ID]; found {
+		return update, f.err
+	}
+	return thread, f.err
+}
+
+func (f *fakeSlack) FetchThreads(ctx context.Context, threads []Conversation) ([]Conversation, error) {
+	fetched := make([]Conversation, 0, len(threads))
+	for _, thread := range threads {
+		thread, err := f.FetchThread(ctx, thread, "")
+		if err != nil {
+			return nil, err
+		}
+		fetched = append(fetched, thread)
+	}
+	return fetched, nil
+}
+
+func (f *fakeSlack) FetchMentions(ctx context.Context, since string, tracked map[string]string) ([]Conversation, error) {
+	f.fetchedMentionsFor = since
+	mentions := make([]Conversation, 0)
+	for _, mention := range f.mentions {
+		if mention.LatestMsgTs > since {
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions, f.err
+}
+
+func (f *fakeSlack) FetchConversationLink(id string, ts string) (string, error) {
+	return fmt.Sprintf("https://fake.slack.com/archives/%s/p%s", id, ts), f.err
+}
+
+func (f *fakeSlack) FetchMessages(ctx context.Context, conversationID string, threadTs string, oldest string, limit int) ([]Message, error) {
+	messages := make([]Message, 0)
+	for _, msg := range f.messages[threadID(conversationID, threadTs)] {
+		if msg.Ts > oldest && (limit == 0 || len(messages) < limit) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, f.err
+}
+
+func (f *fakeSlack) FetchMessage(ctx context.Context, channelID string, threadTs string, ts string) (Message, error) {
+	for _, msg := range f.messages[threadID(channelID, threadTs)] {
+		if msg.Ts == ts {
+			return msg, f.err
+		}
+	}
+	return Message{}, fmt.Errorf("Message %s not found in %s", ts, channelID)
+}
+
+func (f *fakeSlack) PostMessage(ctx context.Context, conversationID string, text string, threadTs string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+
+	ts := fmt.Sprintf("%d.000000", 1000+len(f.posted))
+	f.posted = append(f.posted, Message{Ts: ts, Text: text})
+	key := threadID(conversationID, threadTs)
+	f.messages[key] = append(f.messages[key], Message{Ts: ts, Text: text})
+	return ts, nil
+}
@@ -1,11 +1,14 @@
 module github.com/tomheon/slackbox
 
-go 1.12
+go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/gdamore/tcell v1.3.0
+	github.com/godbus/dbus/v5 v5.0.3
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4
 	github.com/rivo/tview v0.0.0-20191018125527-685bf6da76c2
 	github.com/slack-go/slack v0.7.4
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
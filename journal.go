@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// The actions the journal records.
+const (
+	ackAction      = "ack"
+	unackAction    = "unack"
+	snoozeAction   = "snooze"
+	unsnoozeAction = "unsnooze"
+)
+
+// How many actions are kept in the journal for undoing.
+const journalLength = 100
+
+// An action taken in the inbox, with what's needed to take it back.
+type JournalEntry struct {
+	ID             int64
+	Action         string
+	TeamID         string
+	ConversationID string
+	// the ts acked or unacked through, or snoozed through
+	Ts string
+	// when a snooze ends, zero if it lasts until a new message
+	SnoozedUntil time.Time
+	// the row of the inbox list the conversation was on, or noPosition if
+	// it wasn't in the list
+	Position int
+	// the snooze the conversation had before a snooze or unsnooze, filled in
+	// by Do
+	hadSnooze            bool
+	prevSnoozedThroughTs string
+	prevSnoozedUntil     time.Time
+	// whether the conversation had the ack at Ts before an ack or unack,
+	// filled in by Do
+	hadAck bool
+}
+
+// The Position of actions taken outside the inbox list, which leave the
+// selection where it is when undone or redone.
+const noPosition = -1
+
+func unixOrNull(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+func timeOrZero(secs sql.NullInt64) time.Time {
+	if !secs.Valid {
+		return time.Time{}
+	}
+	return time.Unix(secs.Int64, 0)
+}
+
+func (db *SlackBoxDB) apply(tx *sql.Tx, entry JournalEntry) error {
+	switch entry.Action {
+	case ackAction:
+		return db.ack(tx, entry.TeamID, entry.ConversationID, entry.Ts)
+	case unackAction:
+		return unack(tx, entry.TeamID, entry.ConversationID, entry.Ts)
+	case snoozeAction:
+		return snooze(tx, entry.TeamID, entry.ConversationID, entry.Ts, entry.SnoozedUntil)
+	case unsnoozeAction:
+		return unsnooze(tx, entry.TeamID, entry.ConversationID)
+	default:
+		return fmt.Errorf("Unknown action %s in the journal", entry.Action)
+	}
+}
+
+func (db *SlackBoxDB) revert(tx *sql.Tx, entry JournalEntry) error {
+	switch entry.Action {
+	case ackAction, unackAction:
+		if entry.Action == ackAction && !entry.hadAck {
+			err := unack(tx, entry.TeamID, entry.ConversationID, entry.Ts)
+			if err != nil {
+				return err
+			}
+		}
+		return restoreAcks(tx, entry.ID)
+	case snoozeAction, unsnoozeAction:
+		if entry.hadSnooze {
+			return snooze(tx, entry.TeamID, entry.ConversationID, entry.prevSnoozedThroughTs, entry.prevSnoozedUntil)
+		}
+		return unsnooze(tx, entry.TeamID, entry.ConversationID)
+	default:
+		return fmt.Errorf("Unknown action %s in the journal", entry.Action)
+	}
+}
+
+// The ack timestamps the conversation has, with when each was made.
+func conversationAcks(tx *sql.Tx, teamID string, conversationID string) (map[string]sql.NullInt64, error) {
+	acks := make(map[string]sql.NullInt64)
+
+	rows, err := tx.Query("select acknowledged_through_ts, acknowledged_at from acknowledgements where team_id = ? and conversation_id = ?", teamID, conversationID)
+	if err != nil {
+		return acks, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var ts string
+		var at sql.NullInt64
+		err = rows.Scan(&ts, &at)
+		if err != nil {
+			return acks, err
+		}
+		acks[ts] = at
+	}
+
+	return acks, rows.Err()
+}
+
+// Record which of the acks the conversation had before the journal entry's
+// action are gone after it.
+func recordRemovedAcks(tx *sql.Tx, journalID int64, teamID string, conversationID string, before map[string]sql.NullInt64) error {
+	after, err := conversationAcks(tx, teamID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	insert := `
+      insert into journal_removed_acks
+        (journal_id, team_id, conversation_id, acknowledged_through_ts, acknowledged_at)
+      values
+        (?, ?, ?, ?, ?)
+    `
+	for ts, at := range before {
+		if _, found := after[ts]; found {
+			continue
+		}
+
+		_, err = tx.Exec(insert, journalID, teamID, conversationID, ts, at)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Put back the acks the journal entry's action deleted.
+func restoreAcks(tx *sql.Tx, journalID int64) error {
+	restore := `
+      insert into acknowledgements
+        (team_id, conversation_id, acknowledged_through_ts, acknowledged_at)
+      select
+        team_id, conversation_id, acknowledged_through_ts, acknowledged_at
+      from
+        journal_removed_acks
+      where
+        journal_id = ?
+      on conflict(team_id, conversation_id, acknowledged_through_ts)
+      do nothing
+    `
+	_, err := tx.Exec(restore, journalID)
+	return err
+}
+
+// Take the action and record it in the journal, forgetting anything undone
+// since it can no longer be redone.
+func (db *SlackBoxDB) Do(entry JournalEntry) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if entry.Action == snoozeAction || entry.Action == unsnoozeAction {
+		var prevUntil sql.NullInt64
+		err = tx.QueryRow("select snoozed_through_ts, snoozed_until from snoozes where team_id = ? and conversation_id = ?", entry.TeamID, entry.ConversationID).
+			Scan(&entry.prevSnoozedThroughTs, &prevUntil)
+		entry.hadSnooze = err == nil
+		entry.prevSnoozedUntil = timeOrZero(prevUntil)
+		if err != nil && err != sql.ErrNoRows {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	acks, err := conversationAcks(tx, entry.TeamID, entry.ConversationID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, entry.hadAck = acks[entry.Ts]
+
+	err = db.apply(tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("delete from journal where undone = 1")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	insert := `
+      insert into journal
+        (action, team_id, conversation_id, ts, snoozed_until,
+         prev_snoozed_through_ts, prev_snoozed_until, had_ack, position, done_at)
+      values
+        (?, ?, ?, ?, ?, ?, ?, ?, ?, strftime('%s', 'now'))
+    `
+	var prevThroughTs interface{}
+	if entry.hadSnooze {
+		prevThroughTs = entry.prevSnoozedThroughTs
+	}
+	result, err := tx.Exec(insert, entry.Action, entry.TeamID, entry.ConversationID, entry.Ts, unixOrNull(entry.SnoozedUntil),
+		prevThroughTs, unixOrNull(entry.prevSnoozedUntil), entry.hadAck, entry.Position)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	journalID, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = recordRemovedAcks(tx, journalID, entry.TeamID, entry.ConversationID, acks)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("delete from journal where id <= (select max(id) from journal) - ?", journalLength)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("delete from journal_removed_acks where journal_id not in (select id from journal)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Mark the conversation unread by taking back its latest ack, as the inbox,
+// the recent view and the unack command all do, through the journal.  Returns
+// false if it's never been acked.
+func (db *SlackBoxDB) UnackLatest(c Conversation, position int) (bool, error) {
+	var ts sql.NullString
+	err := db.db.QueryRow("select max(acknowledged_through_ts) from acknowledgements where team_id = ? and conversation_id = ?", c.TeamID, c.ID).Scan(&ts)
+	if err != nil || !ts.Valid {
+		return false, err
+	}
+
+	return true, db.Do(JournalEntry{Action: unackAction, TeamID: c.TeamID, ConversationID: c.ID, Ts: ts.String, Position: position})
+}
+
+// Find the latest action that's still done, or if undone, the earliest one
+// that's been undone.
+func findEntry(tx *sql.Tx, undone bool) (JournalEntry, bool, error) {
+	query := `
+    select
+      id, action, team_id, conversation_id, ts, snoozed_until,
+      prev_snoozed_through_ts, prev_snoozed_until, had_ack, position
+    from
+      journal
+    where
+      undone = ?
+    order by
+      case when undone = 1 then id else -id end
+    limit 1
+    `
+
+	entry := JournalEntry{}
+	var snoozedUntil, prevUntil sql.NullInt64
+	var prevThroughTs sql.NullString
+	err := tx.QueryRow(query, undone).Scan(&entry.ID, &entry.Action, &entry.TeamID, &entry.ConversationID, &entry.Ts, &snoozedUntil,
+		&prevThroughTs, &prevUntil, &entry.hadAck, &entry.Position)
+	if err == sql.ErrNoRows {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+
+	entry.SnoozedUntil = timeOrZero(snoozedUntil)
+	entry.hadSnooze = prevThroughTs.Valid
+	entry.prevSnoozedThroughTs = prevThroughTs.String
+	entry.prevSnoozedUntil = timeOrZero(prevUntil)
+
+	return entry, true, nil
+}
+
+func (db *SlackBoxDB) step(undo bool) (JournalEntry, bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return JournalEntry{}, false, err
+	}
+
+	// undo takes back the latest action that's still done, and redo takes
+	// the earliest one that's been undone
+	entry, found, err := findEntry(tx, !undo)
+	if err != nil || !found {
+		tx.Rollback()
+		return entry, false, err
+	}
+
+	if undo {
+		err = db.revert(tx, entry)
+	} else {
+		err = db.apply(tx, entry)
+	}
+	if err != nil {
+		tx.Rollback()
+		return entry, false, err
+	}
+
+	_, err = tx.Exec("update journal set undone = ? where id = ?", undo, entry.ID)
+	if err != nil {
+		tx.Rollback()
+		return entry, false, err
+	}
+
+	return entry, true, tx.Commit()
+}
+
+// Take back the latest action in the journal, returning it, or false if
+// there's nothing to undo.
+func (db *SlackBoxDB) Undo() (JournalEntry, bool, error) {
+	return db.step(true)
+}
+
+// Take again the last action undone, returning it, or false if there's
+// nothing to redo.
+func (db *SlackBoxDB) Redo() (JournalEntry, bool, error) {
+	return db.step(false)
+}
+
+var actionNames = map[string]string{
+	ackAction:      "read",
+	unackAction:    "unread",
+	snoozeAction:   "snooze",
+	unsnoozeAction: "wake",
+}
+
+// Undo or redo the latest action, then put the list back where it was when
+// the action was taken.
+func (ib *inbox) stepJournal(undo bool) {
+	step, verb, done := ib.db.Redo, "redo", "Redid"
+	if undo {
+		step, verb, done = ib.db.Undo, "undo", "Undid"
+	}
+
+	entry, found, err := step()
+	if err != nil {
+		ib.showModal(fmt.Sprintf("%s", err))
+		return
+	}
+
+	if !found {
+		ib.reportProgress("Nothing to " + verb)
+		return
+	}
+
+	ib.reloadList()
+	ib.selectConversation(entry.TeamID, entry.ConversationID, entry.Position)
+
+	name := entry.ConversationID
+	if c, found, err := ib.db.GetConversation(entry.TeamID, entry.ConversationID); err == nil && found {
+		name = c.DisplayName
+	}
+	ib.reportProgress(fmt.Sprintf("%s %s of %s", done, actionNames[entry.Action], name))
+}
+
+// Select the conversation if it's in the list, or else the row it was on.
+func (ib *inbox) selectConversation(teamID string, id string, position int) {
+	for i, uc := range ib.unacked {
+		if uc.TeamID == teamID && uc.ID == id {
+			ib.list.SetCurrentItem(i)
+			return
+		}
+	}
+
+	if position >= len(ib.unacked) {
+		position = len(ib.unacked) - 1
+	}
+	if position >= 0 {
+		ib.list.SetCurrentItem(position)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func checkDo(t *testing.T, db *SlackBoxDB, entry JournalEntry) {
+	err := db.Do(entry)
+	if err != nil {
+		t.Errorf("Failed doing %s %s", entry.Action, err)
+	}
+}
+
+func checkStep(t *testing.T, step func() (JournalEntry, bool, error), expectedAction string) JournalEntry {
+	entry, found, err := step()
+	if err != nil {
+		t.Fatalf("Failed stepping through the journal %s", err)
+	}
+
+	if expectedAction == "" {
+		if found {
+			t.Errorf("Expected nothing in the journal, got %s", entry.Action)
+		}
+		return entry
+	}
+
+	if !found || entry.Action != expectedAction {
+		t.Errorf("Expected %s from the journal, got %s (found %t)", expectedAction, entry.Action, found)
+	}
+	return entry
+}
+
+func TestUndoRedoAck(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+
+	checkStep(t, db.Undo, "")
+
+	checkDo(t, db, JournalEntry{Action: ackAction, ConversationID: c.ID, Ts: c.LatestMsgTs, Position: 3})
+	checkUnacked(t, db, []Conversation{})
+
+	entry := checkStep(t, db.Undo, ackAction)
+	if entry.ConversationID != c.ID || entry.Position != 3 {
+		t.Errorf("Expected to undo %s at 3, got %s at %d", c.ID, entry.ConversationID, entry.Position)
+	}
+	checkUnacked(t, db, []Conversation{c})
+	checkStep(t, db.Undo, "")
+
+	checkStep(t, db.Redo, ackAction)
+	checkUnacked(t, db, []Conversation{})
+	checkStep(t, db.Redo, "")
+
+	checkDo(t, db, JournalEntry{Action: unackAction, ConversationID: c.ID, Ts: c.LatestMsgTs})
+	checkUnacked(t, db, []Conversation{c})
+	checkStep(t, db.Undo, unackAction)
+	checkUnacked(t, db, []Conversation{})
+
+	// doing something new means the undone unack can't be redone
+	checkDo(t, db, JournalEntry{Action: snoozeAction, ConversationID: c.ID, Ts: c.LatestMsgTs})
+	checkStep(t, db.Redo, "")
+	checkStep(t, db.Undo, snoozeAction)
+	checkStep(t, db.Undo, ackAction)
+	checkUnacked(t, db, []Conversation{c})
+}
+
+func TestUndoSnoozeRestoresPrevious(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+
+	checkDo(t, db, JournalEntry{Action: snoozeAction, ConversationID: c.ID, Ts: c.LatestMsgTs})
+	checkDo(t, db, JournalEntry{Action: snoozeAction, ConversationID: c.ID, Ts: c.LatestMsgTs, SnoozedUntil: time.Now().Add(-time.Hour)})
+	checkUnacked(t, db, []Conversation{c})
+
+	checkStep(t, db.Undo, snoozeAction)
+	checkUnacked(t, db, []Conversation{})
+	checkSnoozed(t, db, []string{c.ID})
+
+	checkDo(t, db, JournalEntry{Action: unsnoozeAction, ConversationID: c.ID})
+	checkUnacked(t, db, []Conversation{c})
+	checkStep(t, db.Undo, unsnoozeAction)
+	checkSnoozed(t, db, []string{c.ID})
+
+	checkStep(t, db.Undo, snoozeAction)
+	checkSnoozed(t, db, []string{})
+	checkUnacked(t, db, []Conversation{c})
+}
+
+func TestUndoAckKeepsEarlierAck(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+	checkAck(t, db, "", c.ID, c.LatestMsgTs)
+
+	// acking again at the same ts changes nothing, so undoing it mustn't
+	// either
+	checkDo(t, db, JournalEntry{Action: ackAction, ConversationID: c.ID, Ts: c.LatestMsgTs})
+	checkStep(t, db.Undo, ackAction)
+	checkUnacked(t, db, []Conversation{})
+
+	checkStep(t, db.Redo, ackAction)
+	checkUnacked(t, db, []Conversation{})
+}
+
+func TestUndoNoOpUnack(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+
+	// never read, so unacking deletes nothing, and undoing it mustn't mark
+	// it read
+	checkDo(t, db, JournalEntry{Action: unackAction, ConversationID: c.ID, Ts: c.LatestMsgTs})
+	checkStep(t, db.Undo, unackAction)
+	checkUnacked(t, db, []Conversation{c})
+}
+
+func TestUndoAckRestoresTrimmedAcks(t *testing.T) {
+	db := memoryDB(t)
+	db.SetAckHistory(1)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "1.0"}
+	checkUpdate(t, db, c)
+	checkAck(t, db, "", c.ID, "1.0")
+
+	c.LatestMsgTs = "2.0"
+	checkUpdate(t, db, c)
+	checkDo(t, db, JournalEntry{Action: ackAction, ConversationID: c.ID, Ts: "2.0"})
+	checkUnacked(t, db, []Conversation{})
+
+	// the ack at 1.0 was trimmed to make room, so it's back once 2.0 is
+	// taken back
+	checkStep(t, db.Undo, ackAction)
+	acked, err := db.GetAcknowledgedConversations()
+	if err != nil {
+		t.Fatalf("GetAcknowledgedConversations failed with error %s", err)
+	}
+	if len(acked) != 1 || acked[0].AcknowledgedThroughTs != "1.0" {
+		t.Errorf("Expected %s acked through 1.0 again, got %v", c.ID, acked)
+	}
+
+	checkStep(t, db.Redo, ackAction)
+	checkUnacked(t, db, []Conversation{})
+	checkStep(t, db.Undo, ackAction)
+	checkUnacked(t, db, []Conversation{c})
+}
+
+func TestUnackLatest(t *testing.T) {
+	db := memoryDB(t)
+
+	c := Conversation{ID: "someconvo", ConversationType: "im", DisplayName: "display", LatestMsgTs: "3.0"}
+	checkUpdate(t, db, c)
+
+	unacked, err := db.UnackLatest(c, 2)
+	if err != nil || unacked {
+		t.Errorf("Expected nothing to unack, got %t %v", unacked, err)
+	}
+	checkStep(t, db.Undo, "")
+
+	// the latest ack is taken back, whatever the conversation's latest
+	// message, leaving the one before
+	checkAck(t, db, "", c.ID, "1.0")
+	checkAck(t, db, "", c.ID, "2.0")
+	unacked, err = db.UnackLatest(c, 2)
+	if err != nil || !unacked {
+		t.Fatalf("Expected to unack, got %t %v", unacked, err)
+	}
+	acked, err := db.GetAcknowledgedConversations()
+	if err != nil {
+		t.Fatalf("GetAcknowledgedConversations failed with error %s", err)
+	}
+	if len(acked) != 1 || acked[0].AcknowledgedThroughTs != "1.0" {
+		t.Errorf("Expected %s acked through 1.0, got %v", c.ID, acked)
+	}
+
+	entry := checkStep(t, db.Undo, unackAction)
+	if entry.Ts != "2.0" || entry.Position != 2 {
+		t.Errorf("Expected the unack of 2.0 at 2 undone, got %s at %d", entry.Ts, entry.Position)
+	}
+}
+
+func TestJournalIsTrimmed(t *testing.T) {
+	db := memoryDB(t)
+
+	for i := 0; i < journalLength+5; i++ {
+		checkDo(t, db, JournalEntry{Action: ackAction, ConversationID: "someconvo", Ts: "1.0"})
+	}
+
+	var count int
+	err := db.db.QueryRow("select count(*) from journal").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed counting the journal %s", err)
+	}
+	if count != journalLength {
+		t.Errorf("Expected %d journal entries, got %d", journalLength, count)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The calls slackbox makes on the freedesktop Secret Service (as provided by
+// gnome-keyring or KWallet), so a test double can stand in for D-Bus.
+type secretService interface {
+	OpenSession() (dbus.ObjectPath, error)
+	CloseSession(session dbus.ObjectPath) error
+	// Find the items with all the attributes, split into those unlocked and
+	// those still locked.
+	SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, error)
+	// Unlock the items, prompting the user if the service needs to.
+	Unlock(items []dbus.ObjectPath) error
+	GetSecret(item dbus.ObjectPath, session dbus.ObjectPath) ([]byte, error)
+}
+
+// Parse attributes like "service=slack account=work", as secret-tool stores
+// them.
+func parseAttributes(spec string) (map[string]string, error) {
+	attributes := make(map[string]string)
+	for _, field := range strings.Fields(spec) {
+		i := strings.Index(field, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Keyring attribute %s should look like name=value", field)
+		}
+		attributes[field[:i]] = field[i+1:]
+	}
+	return attributes, nil
+}
+
+func describeAttributes(attributes map[string]string) string {
+	fields := make([]string, 0, len(attributes))
+	for name, value := range attributes {
+		fields = append(fields, name+"="+value)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+// The secret in the keyring with the attributes given.
+type keyringProvider struct {
+	attributes map[string]string
+	connect    func() (secretService, error)
+}
+
+func (p keyringProvider) Token() (string, error) {
+	service, err := p.connect()
+	if err != nil {
+		return "", fmt.Errorf("Error connecting to the keyring: %s", err)
+	}
+
+	unlocked, locked, err := service.SearchItems(p.attributes)
+	if err != nil {
+		return "", fmt.Errorf("Error searching the keyring: %s", err)
+	}
+
+	var item dbus.ObjectPath
+	switch {
+	case len(unlocked) > 0:
+		item = unlocked[0]
+	case len(locked) > 0:
+		item = locked[0]
+		err = service.Unlock([]dbus.ObjectPath{item})
+		if err != nil {
+			return "", fmt.Errorf("Error unlocking the keyring: %s", err)
+		}
+	default:
+		return "", fmt.Errorf("No secret in the keyring with %s", describeAttributes(p.attributes))
+	}
+
+	session, err := service.OpenSession()
+	if err != nil {
+		return "", fmt.Errorf("Error opening a keyring session: %s", err)
+	}
+	defer service.CloseSession(session)
+
+	secret, err := service.GetSecret(item, session)
+	if err != nil {
+		return "", fmt.Errorf("Error reading the secret with %s from the keyring: %s", describeAttributes(p.attributes), err)
+	}
+
+	return strings.TrimSpace(string(secret)), nil
+}
+
+const (
+	secretsName      = "org.freedesktop.secrets"
+	secretsPath      = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretsInterface = "org.freedesktop.Secret"
+	// what the service returns for a prompt when none is needed
+	noPrompt = dbus.ObjectPath("/")
+	// How long to wait for the user to answer the password prompt before
+	// giving up on the keyring, rather than hang on a prompt they never see.
+	unlockTimeout = 2 * time.Minute
+)
+
+// A secret as the Secret Service sends it.
+type dbusSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// The Secret Service on the session bus.
+type dbusSecretService struct {
+	conn          *dbus.Conn
+	service       dbus.BusObject
+	promptTimeout time.Duration
+}
+
+func newDBusSecretService(conn *dbus.Conn) *dbusSecretService {
+	return &dbusSecretService{
+		conn:          conn,
+		service:       conn.Object(secretsName, secretsPath),
+		promptTimeout: unlockTimeout,
+	}
+}
+
+func connectSecretService() (secretService, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	return newDBusSecretService(conn), nil
+}
+
+// Open a session that passes secrets in plain, which is as safe as the
+// session bus itself.
+func (s *dbusSecretService) OpenSession() (dbus.ObjectPath, error) {
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err := s.service.Call(secretsInterface+".Service.OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	return session, err
+}
+
+func (s *dbusSecretService) CloseSession(session dbus.ObjectPath) error {
+	return s.conn.Object(secretsName, session).Call(secretsInterface+".Session.Close", 0).Err
+}
+
+func (s *dbusSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := s.service.Call(secretsInterface+".Service.SearchItems", 0, attributes).Store(&unlocked, &locked)
+	return unlocked, locked, err
+}
+
+func (s *dbusSecretService) Unlock(items []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.service.Call(secretsInterface+".Service.Unlock", 0, items).Store(&unlocked, &prompt)
+	if err != nil || prompt == noPrompt {
+		return err
+	}
+
+	// the service asks the user for their password, and signals once
+	// they've answered
+	match := []dbus.MatchOption{dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface(secretsInterface + ".Prompt")}
+	err = s.conn.AddMatchSignal(match...)
+	if err != nil {
+		return err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	promptObject := s.conn.Object(secretsName, prompt)
+	err = promptObject.Call(secretsInterface+".Prompt.Prompt", 0, "").Err
+	if err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(s.promptTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case signal, ok := <-signals:
+			if !ok {
+				return errors.New("Lost the session bus while unlocking")
+			}
+			if signal.Path != prompt || signal.Name != secretsInterface+".Prompt.Completed" {
+				continue
+			}
+			// Completed's first argument is whether the user dismissed it
+			if len(signal.Body) > 0 && signal.Body[0] == true {
+				return errors.New("Unlocking was dismissed")
+			}
+			return nil
+		case <-timeout.C:
+			// take the prompt down, so it isn't answered for nothing
+			promptObject.Call(secretsInterface+".Prompt.Dismiss", 0)
+			return fmt.Errorf("No answer to the keyring's password prompt after %s", s.promptTimeout)
+		}
+	}
+}
+
+func (s *dbusSecretService) GetSecret(item dbus.ObjectPath, session dbus.ObjectPath) ([]byte, error) {
+	var secret dbusSecret
+	err := s.conn.Object(secretsName, item).Call(secretsInterface+".Item.GetSecret", 0, session).Store(&secret)
+	return secret.Value, err
+}
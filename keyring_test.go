@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// An in-memory Secret Service holding items by path.
+type fakeSecretService struct {
+	attributes map[dbus.ObjectPath]map[string]string
+	secrets    map[dbus.ObjectPath]string
+	locked     map[dbus.ObjectPath]bool
+	// what Unlock fails with, as if the user dismissed the prompt
+	unlockErr error
+
+	// what the fake was asked for
+	openSessions int
+	unlocked     []dbus.ObjectPath
+}
+
+func (s *fakeSecretService) OpenSession() (dbus.ObjectPath, error) {
+	s.openSessions++
+	return "/org/freedesktop/secrets/session/1", nil
+}
+
+func (s *fakeSecretService) CloseSession(session dbus.ObjectPath) error {
+	s.openSessions--
+	return nil
+}
+
+func (s *fakeSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	for item, itemAttributes := range s.attributes {
+		matches := true
+		for name, value := range attributes {
+			if itemAttributes[name] != value {
+				matches = false
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		if s.locked[item] {
+			locked = append(locked, item)
+		} else {
+			unlocked = append(unlocked, item)
+		}
+	}
+	return unlocked, locked, nil
+}
+
+func (s *fakeSecretService) Unlock(items []dbus.ObjectPath) error {
+	if s.unlockErr != nil {
+		return s.unlockErr
+	}
+	for _, item := range items {
+		s.locked[item] = false
+		s.unlocked = append(s.unlocked, item)
+	}
+	return nil
+}
+
+func (s *fakeSecretService) GetSecret(item dbus.ObjectPath, session dbus.ObjectPath) ([]byte, error) {
+	if s.locked[item] {
+		return nil, errors.New("Item is locked")
+	}
+	if s.openSessions == 0 {
+		return nil, errors.New("No session")
+	}
+	return []byte(s.secrets[item]), nil
+}
+
+func newFakeSecretService() *fakeSecretService {
+	return &fakeSecretService{
+		attributes: map[dbus.ObjectPath]map[string]string{
+			"/item/work":  {"service": "slack", "account": "work"},
+			"/item/club":  {"service": "slack", "account": "club"},
+			"/item/email": {"service": "email", "account": "work"},
+		},
+		secrets: map[dbus.ObjectPath]string{
+			"/item/work":  "xoxp-work\n",
+			"/item/club":  "xoxp-club",
+			"/item/email": "hunter2",
+		},
+		locked: map[dbus.ObjectPath]bool{"/item/club": true},
+	}
+}
+
+func keyringFor(service secretService, spec string) keyringProvider {
+	attributes, _ := parseAttributes(spec)
+	return keyringProvider{
+		attributes: attributes,
+		connect:    func() (secretService, error) { return service, nil },
+	}
+}
+
+func TestKeyringProvider(t *testing.T) {
+	service := newFakeSecretService()
+
+	token, err := keyringFor(service, "service=slack account=work").Token()
+	if err != nil {
+		t.Fatalf("Reading the token failed with error %s", err)
+	}
+	if token != "xoxp-work" {
+		t.Errorf("Expected token xoxp-work, got %q", token)
+	}
+	if len(service.unlocked) != 0 {
+		t.Errorf("Expected nothing unlocked, got %v", service.unlocked)
+	}
+
+	token, err = keyringFor(service, "service=slack account=club").Token()
+	if err != nil {
+		t.Fatalf("Reading the locked token failed with error %s", err)
+	}
+	if token != "xoxp-club" {
+		t.Errorf("Expected token xoxp-club, got %q", token)
+	}
+	if len(service.unlocked) != 1 || service.unlocked[0] != "/item/club" {
+		t.Errorf("Expected the club item unlocked, got %v", service.unlocked)
+	}
+
+	if service.openSessions != 0 {
+		t.Errorf("Expected every session closed, %d still open", service.openSessions)
+	}
+}
+
+func TestKeyringProviderErrors(t *testing.T) {
+	service := newFakeSecretService()
+	_, err := keyringFor(service, "service=slack account=home").Token()
+	if err == nil || !strings.Contains(err.Error(), "No secret in the keyring with account=home service=slack") {
+		t.Errorf("Expected an error for a missing secret, got %v", err)
+	}
+
+	service.unlockErr = errors.New("Unlocking was dismissed")
+	_, err = keyringFor(service, "service=slack account=club").Token()
+	if err == nil || !strings.Contains(err.Error(), "Unlocking was dismissed") {
+		t.Errorf("Expected an error when unlocking is dismissed, got %v", err)
+	}
+
+	provider := keyringProvider{
+		attributes: map[string]string{"service": "slack"},
+		connect:    func() (secretService, error) { return nil, errors.New("No session bus") },
+	}
+	_, err = provider.Token()
+	if err == nil || !strings.Contains(err.Error(), "Error connecting to the keyring") {
+		t.Errorf("Expected an error without a keyring, got %v", err)
+	}
+}
+
+// The in-memory Secret Service above, exported on a bus as gnome-keyring
+// would export it.
+type fakeSecretBus struct {
+	conn *dbus.Conn
+	// held while answering a call, since godbus answers each in a goroutine
+	// of its own
+	mu    sync.Mutex
+	items *fakeSecretService
+	// the algorithms sessions were opened with
+	algorithms []string
+	// how the password prompt is answered: dismissed, or never at all
+	dismiss      bool
+	ignorePrompt bool
+	// how many prompts were shown, and taken down unanswered
+	prompted  int
+	dismissed int
+}
+
+type fakeSecretBusService struct{ bus *fakeSecretBus }
+
+func (s fakeSecretBusService) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.algorithms = append(s.bus.algorithms, algorithm)
+	if algorithm != "plain" {
+		return dbus.MakeVariant(""), noPrompt, &dbus.Error{Name: "org.freedesktop.DBus.Error.NotSupported"}
+	}
+	session, _ := s.bus.items.OpenSession()
+	return dbus.MakeVariant(""), session, nil
+}
+
+func (s fakeSecretBusService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	unlocked, locked, _ := s.bus.items.SearchItems(attributes)
+	return unlocked, locked, nil
+}
+
+// Unlocking always prompts, and the items are unlocked once it's answered.
+func (s fakeSecretBusService) Unlock(items []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	prompt := fakeSecretBusPrompt{bus: s.bus, path: "/org/freedesktop/secrets/prompt/1", items: items}
+	err := s.bus.conn.Export(prompt, prompt.path, secretsInterface+".Prompt")
+	if err != nil {
+		return nil, noPrompt, dbus.MakeFailedError(err)
+	}
+	return []dbus.ObjectPath{}, prompt.path, nil
+}
+
+type fakeSecretBusSession struct{ bus *fakeSecretBus }
+
+func (s fakeSecretBusSession) Close() *dbus.Error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.items.CloseSession("")
+	return nil
+}
+
+type fakeSecretBusItem struct {
+	bus  *fakeSecretBus
+	path dbus.ObjectPath
+}
+
+func (i fakeSecretBusItem) GetSecret(session dbus.ObjectPath) (dbusSecret, *dbus.Error) {
+	i.bus.mu.Lock()
+	defer i.bus.mu.Unlock()
+
+	secret, err := i.bus.items.GetSecret(i.path, session)
+	if err != nil {
+		return dbusSecret{}, dbus.MakeFailedError(err)
+	}
+	return dbusSecret{Session: session, Parameters: []byte{}, Value: secret, ContentType: "text/plain"}, nil
+}
+
+type fakeSecretBusPrompt struct {
+	bus   *fakeSecretBus
+	path  dbus.ObjectPath
+	items []dbus.ObjectPath
+}
+
+func (p fakeSecretBusPrompt) Prompt(windowID string) *dbus.Error {
+	p.bus.mu.Lock()
+	defer p.bus.mu.Unlock()
+
+	p.bus.prompted++
+	if p.bus.ignorePrompt {
+		return nil
+	}
+
+	if !p.bus.dismiss {
+		p.bus.items.Unlock(p.items)
+	}
+	err := p.bus.conn.Emit(p.path, secretsInterface+".Prompt.Completed", p.bus.dismiss, dbus.MakeVariant(p.items))
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (p fakeSecretBusPrompt) Dismiss() *dbus.Error {
+	p.bus.mu.Lock()
+	defer p.bus.mu.Unlock()
+
+	p.bus.dismissed++
+	return nil
+}
+
+// Start a bus of the test's own in dir, so the real session bus and keyring
+// are never touched, returning its address and the daemon to stop.
+func startPrivateBus(t *testing.T, dir string) (string, *exec.Cmd) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon isn't installed")
+	}
+
+	config := fmt.Sprintf(`<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`, filepath.Join(dir, "bus"))
+	configPath := filepath.Join(dir, "bus.conf")
+	err = ioutil.WriteFile(configPath, []byte(config), 0600)
+	if err != nil {
+		t.Fatalf("Writing the bus config failed with error %s", err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+configPath, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Piping from dbus-daemon failed with error %s", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatalf("Starting dbus-daemon failed with error %s", err)
+	}
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("Reading the bus address failed with error %s", err)
+	}
+	return strings.TrimSpace(address), cmd
+}
+
+func dialBus(t *testing.T, address string) *dbus.Conn {
+	conn, err := dbus.Dial(address)
+	if err != nil {
+		t.Fatalf("Connecting to the bus failed with error %s", err)
+	}
+
+	err = conn.Auth(nil)
+	if err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		conn.Close()
+		t.Fatalf("Joining the bus failed with error %s", err)
+	}
+	return conn
+}
+
+// Export the fake Secret Service on a private bus, returning the service as
+// slackbox would see it and a func to stop the bus with.
+func startFakeSecretBus(t *testing.T) (*fakeSecretBus, *dbusSecretService, func()) {
+	dir, err := ioutil.TempDir("", "slackbox-bus")
+	if err != nil {
+		t.Fatalf("Creating a dir for the bus failed with error %s", err)
+	}
+
+	address, daemon := startPrivateBus(t, dir)
+	bus := &fakeSecretBus{items: newFakeSecretService()}
+	var client *dbus.Conn
+	stop := func() {
+		for _, conn := range []*dbus.Conn{bus.conn, client} {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+		daemon.Process.Kill()
+		daemon.Wait()
+		os.RemoveAll(dir)
+	}
+
+	bus.conn = dialBus(t, address)
+	exports := map[dbus.ObjectPath]interface{}{
+		secretsPath:                          fakeSecretBusService{bus: bus},
+		"/org/freedesktop/secrets/session/1": fakeSecretBusSession{bus: bus},
+	}
+	interfaces := map[dbus.ObjectPath]string{
+		secretsPath:                          secretsInterface + ".Service",
+		"/org/freedesktop/secrets/session/1": secretsInterface + ".Session",
+	}
+	for item := range bus.items.attributes {
+		exports[item] = fakeSecretBusItem{bus: bus, path: item}
+		interfaces[item] = secretsInterface + ".Item"
+	}
+	for path, object := range exports {
+		err = bus.conn.Export(object, path, interfaces[path])
+		if err != nil {
+			stop()
+			t.Fatalf("Exporting %s failed with error %s", path, err)
+		}
+	}
+
+	reply, err := bus.conn.RequestName(secretsName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		stop()
+		t.Fatalf("Taking the name %s failed with reply %d and error %v", secretsName, reply, err)
+	}
+
+	client = dialBus(t, address)
+	return bus, newDBusSecretService(client), stop
+}
+
+func TestDBusSecretService(t *testing.T) {
+	bus, service, stop := startFakeSecretBus(t)
+	defer stop()
+
+	token, err := keyringFor(service, "service=slack account=work").Token()
+	if err != nil {
+		t.Fatalf("Reading the token failed with error %s", err)
+	}
+	if token != "xoxp-work" {
+		t.Errorf("Expected token xoxp-work, got %q", token)
+	}
+	bus.mu.Lock()
+	if len(bus.algorithms) != 1 || bus.algorithms[0] != "plain" {
+		t.Errorf("Expected a plain session opened, got %v", bus.algorithms)
+	}
+	bus.mu.Unlock()
+
+	token, err = keyringFor(service, "service=slack account=club").Token()
+	if err != nil {
+		t.Fatalf("Reading the locked token failed with error %s", err)
+	}
+	if token != "xoxp-club" {
+		t.Errorf("Expected token xoxp-club, got %q", token)
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.prompted != 1 {
+		t.Errorf("Expected the user prompted once, got %d", bus.prompted)
+	}
+	if bus.items.openSessions != 0 {
+		t.Errorf("Expected every session closed, %d still open", bus.items.openSessions)
+	}
+}
+
+func TestDBusSecretServiceUnanswered(t *testing.T) {
+	bus, service, stop := startFakeSecretBus(t)
+	defer stop()
+	bus.mu.Lock()
+	bus.dismiss = true
+	bus.mu.Unlock()
+
+	_, err := keyringFor(service, "service=slack account=club").Token()
+	if err == nil || !strings.Contains(err.Error(), "Unlocking was dismissed") {
+		t.Errorf("Expected an error when unlocking is dismissed, got %v", err)
+	}
+
+	// a prompt the user never sees can't hold up starting forever
+	bus.mu.Lock()
+	bus.dismiss, bus.ignorePrompt = false, true
+	bus.mu.Unlock()
+	service.promptTimeout = 100 * time.Millisecond
+	_, err = keyringFor(service, "service=slack account=club").Token()
+	if err == nil || !strings.Contains(err.Error(), "No answer to the keyring's password prompt") {
+		t.Errorf("Expected an error when the prompt goes unanswered, got %v", err)
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.dismissed != 1 {
+		t.Errorf("Expected the unanswered prompt taken down, got %d dismissed", bus.dismissed)
+	}
+}
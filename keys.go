@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The actions keys can be bound to in the inbox.
+const (
+	downKey             = "down"
+	upKey               = "up"
+	refreshKey          = "refresh"
+	refreshWorkspaceKey = "refresh_workspace"
+	ackKey              = "ack"
+	unackKey            = "unack"
+	snoozeKey           = "snooze"
+	snoozedKey          = "snoozed"
+	recentKey           = "recent"
+	undoKey             = "undo"
+	redoKey             = "redo"
+	replyKey            = "reply"
+	replyThreadKey      = "reply_thread"
+	helpKey             = "help"
+	quitKey             = "quit"
+)
+
+// Maps each action to the keys that take it, e.g. "?h" for help.
+type keyBindings map[string]string
+
+func defaultKeyBindings() keyBindings {
+	return keyBindings{
+		downKey:             "j",
+		upKey:               "k",
+		refreshKey:          "g",
+		refreshWorkspaceKey: "G",
+		ackKey:              "r",
+		unackKey:            "u",
+		snoozeKey:           "s",
+		snoozedKey:          "z",
+		recentKey:           "a",
+		undoKey:             "U",
+		redoKey:             "R",
+		replyKey:            "c",
+		replyThreadKey:      "t",
+		helpKey:             "?h",
+		quitKey:             "q",
+	}
+}
+
+// Map each key to the action it takes, refusing unknown actions and keys
+// bound to more than one.
+func (b keyBindings) actions() (map[rune]string, error) {
+	defaults := defaultKeyBindings()
+
+	names := make([]string, 0, len(b))
+	for action := range b {
+		names = append(names, action)
+	}
+	sort.Strings(names)
+
+	actions := make(map[rune]string)
+	for _, action := range names {
+		if _, found := defaults[action]; !found {
+			return nil, fmt.Errorf("No action %s to bind keys to", action)
+		}
+
+		for _, key := range b[action] {
+			if other, found := actions[key]; found {
+				return nil, fmt.Errorf("%q is bound to both %s and %s", string(key), other, action)
+			}
+			actions[key] = action
+		}
+	}
+
+	return actions, nil
+}
+
+// The keys bound to the action, for showing in help, e.g. "? or h".
+func (b keyBindings) names(action string) string {
+	keys := make([]string, 0)
+	for _, key := range b[action] {
+		keys = append(keys, string(key))
+	}
+
+	if len(keys) == 0 {
+		return "(unbound)"
+	}
+	return strings.Join(keys, " or ")
+}
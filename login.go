@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Where slack is, and how to get a token from it for the slack app given.
+type oauthOptions struct {
+	// e.g. https://slack.com/, so a fake can stand in
+	slackURL     string
+	clientID     string
+	clientSecret string
+	// the port on localhost slack redirects back to, which must match a
+	// redirect URL of the app
+	port int
+	// how long to wait for the user to approve in their browser
+	timeout time.Duration
+	openURL func(string) error
+	out     io.Writer
+}
+
+// What logging in got.
+type oauthToken struct {
+	token    string
+	teamName string
+	scopes   []string
+}
+
+// Slack's reply to oauth.v2.access.
+type oauthAccessResponse struct {
+	OK         bool   `json:"ok"`
+	Error      string `json:"error"`
+	AuthedUser struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+	} `json:"authed_user"`
+	Team struct {
+		Name string `json:"name"`
+	} `json:"team"`
+}
+
+// What the browser brought back to the redirect listener.
+type oauthCallback struct {
+	code string
+	err  error
+}
+
+// Random, so a redirect that didn't come from our request is refused.
+func newOAuthState() (string, error) {
+	var state [16]byte
+	_, err := rand.Read(state[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(state[:]), nil
+}
+
+// Run the OAuth v2 flow: send the user to slack to approve slackbox, listen
+// on localhost for slack to redirect back with a code, and exchange the code
+// for a user token.
+func oauthLogin(opts oauthOptions) (oauthToken, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.port))
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("Error listening for slack's redirect: %s", err)
+	}
+	redirectURL := fmt.Sprintf("http://localhost:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+
+	state, err := newOAuthState()
+	if err != nil {
+		listener.Close()
+		return oauthToken{}, err
+	}
+
+	callbacks := make(chan oauthCallback, 1)
+	server := &http.Server{Handler: oauthCallbackHandler(state, callbacks)}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	authorizeURL := opts.slackURL + "oauth/v2/authorize?" + url.Values{
+		"client_id":    {opts.clientID},
+		"user_scope":   {strings.Join(allScopes(), ",")},
+		"redirect_uri": {redirectURL},
+		"state":        {state},
+	}.Encode()
+
+	fmt.Fprintf(opts.out, "Approve slackbox in your browser, or if it doesn't open, visit\n\n  %s\n\n", authorizeURL)
+	err = opts.openURL(authorizeURL)
+	if err != nil {
+		fmt.Fprintf(opts.out, "Couldn't open the browser: %s\n", err)
+	}
+
+	var callback oauthCallback
+	select {
+	case callback = <-callbacks:
+	case <-time.After(opts.timeout):
+		return oauthToken{}, fmt.Errorf("Gave up waiting for approval after %s", opts.timeout)
+	}
+	if callback.err != nil {
+		return oauthToken{}, callback.err
+	}
+
+	return exchangeOAuthCode(opts, callback.code, redirectURL)
+}
+
+// Handle slack redirecting the browser back, passing on the code or why
+// there isn't one.
+func oauthCallbackHandler(state string, callbacks chan<- oauthCallback) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var callback oauthCallback
+		switch {
+		case query.Get("state") != state:
+			// not from our request, so leave waiting for the real one
+			http.Error(w, "This isn't the login slackbox started.", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			callback.err = fmt.Errorf("Slack didn't approve the login: %s", query.Get("error"))
+		case query.Get("code") == "":
+			callback.err = errors.New("Slack redirected back without a code")
+		default:
+			callback.code = query.Get("code")
+		}
+
+		if callback.err != nil {
+			fmt.Fprintf(w, "Logging in to slackbox failed: %s\n", callback.err)
+		} else {
+			fmt.Fprintln(w, "Logged in to slackbox, you can close this tab.")
+		}
+
+		select {
+		case callbacks <- callback:
+		default:
+			// already logged in, or failed to
+		}
+	})
+	return mux
+}
+
+func exchangeOAuthCode(opts oauthOptions, code string, redirectURL string) (oauthToken, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(opts.slackURL+"api/oauth.v2.access", url.Values{
+		"client_id":     {opts.clientID},
+		"client_secret": {opts.clientSecret},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+	})
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("Error getting a token from slack: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var access oauthAccessResponse
+	err = json.NewDecoder(resp.Body).Decode(&access)
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("Error reading the token from slack (%s): %s", resp.Status, err)
+	}
+	if !access.OK {
+		return oauthToken{}, fmt.Errorf("Slack refused to give a token: %s", access.Error)
+	}
+	if access.AuthedUser.AccessToken == "" {
+		return oauthToken{}, errors.New("Slack gave no user token")
+	}
+
+	return oauthToken{
+		token:    access.AuthedUser.AccessToken,
+		teamName: access.Team.Name,
+		scopes:   strings.Split(access.AuthedUser.Scope, ","),
+	}, nil
+}
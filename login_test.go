@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A local stand in for slack's OAuth endpoints, approving every request
+// with the code given unless it's set to deny them.
+type fakeOAuthServer struct {
+	*httptest.Server
+	clientID     string
+	clientSecret string
+	code         string
+	deny         bool
+
+	// the scopes the user was asked to approve
+	requestedScopes string
+}
+
+func newFakeOAuthServer(t *testing.T) *fakeOAuthServer {
+	s := &fakeOAuthServer{clientID: "1234.5678", clientSecret: "shh", code: "approved-code"}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/v2/authorize":
+			query := r.URL.Query()
+			s.requestedScopes = query.Get("user_scope")
+
+			redirect, err := url.Parse(query.Get("redirect_uri"))
+			if err != nil || query.Get("client_id") != s.clientID {
+				http.Error(w, "bad authorize request", http.StatusBadRequest)
+				return
+			}
+
+			params := url.Values{"state": {query.Get("state")}}
+			if s.deny {
+				params.Set("error", "access_denied")
+			} else {
+				params.Set("code", s.code)
+			}
+			redirect.RawQuery = params.Encode()
+			http.Redirect(w, r, redirect.String(), http.StatusFound)
+
+		case "/api/oauth.v2.access":
+			var reply interface{}
+			switch {
+			case r.PostFormValue("client_id") != s.clientID || r.PostFormValue("client_secret") != s.clientSecret:
+				reply = map[string]interface{}{"ok": false, "error": "invalid_client"}
+			case r.PostFormValue("code") != s.code:
+				reply = map[string]interface{}{"ok": false, "error": "invalid_code"}
+			case !strings.HasPrefix(r.PostFormValue("redirect_uri"), "http://localhost:"):
+				reply = map[string]interface{}{"ok": false, "error": "bad_redirect_uri"}
+			default:
+				reply = map[string]interface{}{
+					"ok":          true,
+					"authed_user": map[string]interface{}{"access_token": "xoxp-login", "scope": s.requestedScopes},
+					"team":        map[string]interface{}{"name": "Test Team"},
+				}
+			}
+			json.NewEncoder(w).Encode(reply)
+
+		default:
+			t.Errorf("Unexpected request to %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+
+	return s
+}
+
+// Opens URLs as a browser would, following redirects.
+func fakeBrowser(t *testing.T) func(string) error {
+	return func(link string) error {
+		resp, err := http.Get(link)
+		if err != nil {
+			t.Errorf("Browsing to %s failed with error %s", link, err)
+			return err
+		}
+		return resp.Body.Close()
+	}
+}
+
+func testOAuthOptions(t *testing.T, server *fakeOAuthServer) oauthOptions {
+	return oauthOptions{
+		slackURL:     server.URL + "/",
+		clientID:     server.clientID,
+		clientSecret: server.clientSecret,
+		timeout:      10 * time.Second,
+		openURL:      fakeBrowser(t),
+		out:          ioutil.Discard,
+	}
+}
+
+func TestOAuthLogin(t *testing.T) {
+	server := newFakeOAuthServer(t)
+	defer server.Close()
+
+	token, err := oauthLogin(testOAuthOptions(t, server))
+	if err != nil {
+		t.Fatalf("Logging in failed with error %s", err)
+	}
+	if token.token != "xoxp-login" || token.teamName != "Test Team" {
+		t.Errorf("Expected token xoxp-login for Test Team, got %v", token)
+	}
+	if server.requestedScopes != strings.Join(allScopes(), ",") {
+		t.Errorf("Expected scopes %v requested, got %s", allScopes(), server.requestedScopes)
+	}
+}
+
+func TestOAuthLoginErrors(t *testing.T) {
+	server := newFakeOAuthServer(t)
+	defer server.Close()
+
+	server.deny = true
+	_, err := oauthLogin(testOAuthOptions(t, server))
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("Expected an error when the user denies the login, got %v", err)
+	}
+
+	server.deny = false
+	opts := testOAuthOptions(t, server)
+	opts.clientSecret = "wrong"
+	_, err = oauthLogin(opts)
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("Expected an error with the wrong client secret, got %v", err)
+	}
+
+	// a browser that never comes back
+	opts = testOAuthOptions(t, server)
+	opts.openURL = func(string) error { return nil }
+	opts.timeout = 10 * time.Millisecond
+	_, err = oauthLogin(opts)
+	if err == nil || !strings.Contains(err.Error(), "Gave up waiting") {
+		t.Errorf("Expected an error when approval never comes, got %v", err)
+	}
+}
+
+func TestOAuthCallbackIgnoresOtherStates(t *testing.T) {
+	callbacks := make(chan oauthCallback, 1)
+	handler := oauthCallbackHandler("expected", callbacks)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/callback?state=forged&code=evil", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a forged redirect refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/callback?state=expected&code=good", nil))
+	callback := <-callbacks
+	if callback.err != nil || callback.code != "good" {
+		t.Errorf("Expected code good, got %v", callback)
+	}
+}
+
+func TestLoginCommand(t *testing.T) {
+	server := newFakeOAuthServer(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "slackbox-login")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	os.Setenv("SLACKBOX_CLIENT_SECRET", server.clientSecret)
+	defer os.Unsetenv("SLACKBOX_CLIENT_SECRET")
+
+	s := defaultSettings()
+	s.TokenPaths = []string{path}
+	out := &bytes.Buffer{}
+	env := &commandEnv{settings: s, slackURL: server.URL + "/", openURL: fakeBrowser(t), out: out}
+
+	err = runCommand([]string{"login", "--client-id", server.clientID, "--port", "0"}, env)
+	if err != nil {
+		t.Fatalf("Logging in failed with error %s", err)
+	}
+	if !strings.Contains(out.String(), "Logged in to Test Team") {
+		t.Errorf("Expected the login reported, got %s", out.String())
+	}
+
+	token, err := fileProvider{path: path}.Token()
+	if err != nil || token != "xoxp-login" {
+		t.Errorf("Expected token xoxp-login stored privately, got %q %v", token, err)
+	}
+
+	err = runCommand([]string{"login", "--client-id", server.clientID, "--port", "0", "env:SLACK_TOKEN"}, env)
+	if err == nil || !strings.Contains(err.Error(), "Can't store a token in env:SLACK_TOKEN") {
+		t.Errorf("Expected an error logging in to an env var, got %v", err)
+	}
+
+	err = runCommand([]string{"login", "--port", "0"}, env)
+	if err == nil || !strings.Contains(err.Error(), "No client id") {
+		t.Errorf("Expected an error without a client id, got %v", err)
+	}
+}
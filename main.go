@@ -1,44 +1,44 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gdamore/tcell"
 	"github.com/pkg/browser"
 	"github.com/rivo/tview"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-func mustHaveToken(tokenPath string) string {
-	info, err := os.Stat(tokenPath)
-	if err != nil {
-		log.Fatalf("Error stating tokenpath %s %s", tokenPath, err)
-	}
-
-	if (info.Mode().Perm() & 0077) != 0 {
-		log.Fatalf("Tokenpath %s is accessible to group or world with perms %s, exiting...", tokenPath, info.Mode().Perm())
-	}
+func mustConnectWorkspaces(tokenPaths []string, opts APIOptions, db *SlackBoxDB) []*SlackBoxAPI {
+	apis, err := connectWorkspaces(tokenPaths, opts, db)
 
-	dat, err := ioutil.ReadFile(tokenPath)
 	if err != nil {
-		log.Fatalf("Error reading tokenpath %s %s", tokenPath, err)
+		log.Fatalf("Erroring connecting to slack: %s", err)
 	}
 
-	return strings.TrimSpace(string(dat))
-}
-
-func mustConnectAPI(token string) *SlackBoxAPI {
-	api, err := ConnectAPI(token)
-
-	if err != nil {
-		log.Fatalf("Erroring connecting to slack: %s", err)
+	// say what won't work before the TUI hides it, and wait for it to be
+	// read; it's shown again once the TUI exits
+	reported := false
+	for _, api := range apis {
+		if len(api.missingScopes) > 0 {
+			log.Println(scopeReport(api.TeamName(), api.missingScopes))
+			reported = true
+		}
+	}
+	if reported && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Press Enter to start slackbox anyway")
+		stdinLines.ReadString('\n')
 	}
 
-	return api
+	return apis
 }
 
 func mustConnectDB(dbPath string) *SlackBoxDB {
@@ -58,151 +58,757 @@ func silenceBrowserOutput() {
 	browser.Stdout = ioutil.Discard
 }
 
-func updateAndFindUnacked(api *SlackBoxAPI, db *SlackBoxDB) ([]AcknowledgedConversation, error) {
-	unacked := make([]AcknowledgedConversation, 0)
+// Make sure the api knows every user's name, refreshing its workspace's
+// directory stored in the db from slack if it's older than userTTL.
+func updateUsers(ctx context.Context, api SlackAPI, db *SlackBoxDB, userTTL time.Duration) error {
+	fetchedAt, err := db.GetUsersFetchedAt(api.TeamID())
+	if err != nil {
+		return err
+	}
+
+	if time.Since(fetchedAt) > userTTL {
+		users, err := api.FetchUsers(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = db.UpdateUsers(api.TeamID(), users, time.Now())
+		if err != nil {
+			return err
+		}
+	}
 
-	conversations, err := api.FetchConversations()
+	users, err := db.GetUsers(api.TeamID())
 	if err != nil {
-		return unacked, err
+		return err
+	}
+
+	api.SetUsers(users)
+	return nil
+}
+
+// How a refresh from slack should go.
+type refreshOptions struct {
+	// how long to trust the stored user directory
+	userTTL time.Duration
+	// followed threads are only refreshed until they've been quiet this long
+	threadLookback time.Duration
+}
+
+// Bring the db up to date with the api's workspace.
+func updateFromSlack(ctx context.Context, api SlackAPI, db *SlackBoxDB, opts refreshOptions) error {
+	err := updateUsers(ctx, api, db, opts.userTTL)
+	if err != nil {
+		return err
+	}
+
+	latestMsgTimestamps, err := db.GetLatestMsgTimestamps(api.TeamID())
+	if err != nil {
+		return err
+	}
+
+	// threads found by this refresh are fetched along with their
+	// conversations, so only the ones we already follow need refreshing
+	threads, err := db.GetThreads(api.TeamID(), timeToSlackTs(time.Now().Add(-opts.threadLookback)))
+	if err != nil {
+		return err
+	}
+
+	conversations, err := api.FetchConversations(ctx, latestMsgTimestamps)
+	if err != nil {
+		return err
 	}
 	err = db.UpdateConversations(conversations)
 	if err != nil {
-		return unacked, err
+		return err
 	}
 
-	return db.GetUnackedConversations()
+	threads, err = api.FetchThreads(ctx, threads)
+	if err != nil {
+		return err
+	}
+	err = db.UpdateConversations(threads)
+	if err != nil {
+		return err
+	}
+
+	// mentions are only fetched now, so we know what's tracked
+	tracked, err := db.GetLatestMsgTimestamps(api.TeamID())
+	if err != nil {
+		return err
+	}
+	latestMentionTs, err := db.GetLatestMentionTs(api.TeamID())
+	if err != nil {
+		return err
+	}
+	mentions, err := api.FetchMentions(ctx, latestMentionTs, tracked)
+	if err != nil {
+		return err
+	}
+	return db.UpdateConversations(mentions)
 }
 
-func showModal(msg string, app *tview.Application, list *tview.List) {
+// How the inbox looks and responds to keys.
+type displayOptions struct {
+	keys keyBindings
+	// what each key does, from keys
+	actions map[rune]string
+	theme   theme
+	openURL func(string) error
+}
+
+// The state of the running TUI: the list of unacked conversations and what's
+// needed to refresh it.
+type inbox struct {
+	// cancelled when the user quits, to abandon any fetch in progress
+	ctx  context.Context
+	quit context.CancelFunc
+	// one for each workspace, in the order they were given
+	apis    []SlackAPI
+	db      *SlackBoxDB
+	refresh refreshOptions
+	display displayOptions
+	app     *tview.Application
+	root    *tview.Flex
+	list    *tview.List
+	preview *tview.TextView
+	status  *tview.TextView
+	// the item shown in each row of the list; section headers are blank
+	unacked []AcknowledgedConversation
+	// set while the list is being rebuilt
+	rebuilding bool
+	// the ids of the workspaces with a refresh from slack running in the
+	// background
+	refreshing map[string]bool
+	// ids of the conversations that have had new messages since the user
+	// last moved onto them
+	arrived map[conversationKey]bool
+	// progress reports are numbered so that a late one can't overwrite a
+	// newer one already shown
+	progressReported int32
+	progressShown    int32
+	// the latest rendered preview of each conversation by id, and the
+	// previewKeys of those still being fetched
+	previews        map[conversationKey]renderedPreview
+	loadingPreviews map[string]bool
+}
+
+func newInbox(apis []SlackAPI, db *SlackBoxDB, refresh refreshOptions, display displayOptions, app *tview.Application) *inbox {
+	list := tview.NewList()
+	preview := tview.NewTextView()
+	status := tview.NewTextView()
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tview.NewFlex().
+			AddItem(list, 0, 1, true).
+			AddItem(preview, 0, 2, false), 0, 1, true).
+		AddItem(status, 1, 0, false)
+	ctx, quit := context.WithCancel(context.Background())
+	ib := &inbox{
+		ctx:             ctx,
+		quit:            quit,
+		apis:            apis,
+		db:              db,
+		refresh:         refresh,
+		display:         display,
+		app:             app,
+		root:            root,
+		list:            list,
+		preview:         preview,
+		status:          status,
+		refreshing:      make(map[string]bool),
+		arrived:         make(map[conversationKey]bool),
+		previews:        make(map[conversationKey]renderedPreview),
+		loadingPreviews: make(map[string]bool),
+	}
+
+	list.ShowSecondaryText(false)
+	list.SetDoneFunc(ib.stop)
+	list.SetBorder(true)
+	teamNames := make([]string, 0, len(apis))
+	for _, api := range apis {
+		teamNames = append(teamNames, api.TeamName())
+	}
+	list.SetTitle(fmt.Sprintf("%s (? or h for help)", strings.Join(teamNames, ", ")))
+
+	list.SetInputCapture(ib.createInputCaptureFunc())
+	list.SetChangedFunc(func(int, string, string, rune) {
+		// showUnacked shows the preview itself once the list is rebuilt
+		if ib.rebuilding {
+			return
+		}
+		ib.markSeen()
+		ib.showPreview()
+	})
+
+	preview.SetBorder(true)
+	preview.SetDynamicColors(true)
+	preview.SetWordWrap(true)
+
+	status.SetDynamicColors(true)
+	for _, api := range apis {
+		api := api
+		api.OnProgress(func(msg string) {
+			ib.reportProgress(ib.teamPrefix(api) + msg)
+		})
+	}
+
+	app.SetRoot(root, true)
+
+	return ib
+}
+
+// The api for the workspace the conversation is in.
+func (ib *inbox) apiFor(c Conversation) (SlackAPI, error) {
+	return apiForTeam(ib.apis, c.TeamID)
+}
+
+// With more than one workspace, what to put before a message or
+// conversation to say which it's about.
+func (ib *inbox) teamPrefix(api SlackAPI) string {
+	if len(ib.apis) < 2 {
+		return ""
+	}
+	return api.TeamName() + ": "
+}
+
+// Where a refresh of the api's workspace comes from, naming the workspace if
+// there's more than one.
+func (ib *inbox) fromSlack(api SlackAPI) string {
+	if len(ib.apis) < 2 {
+		return "from slack"
+	}
+	return api.TeamName() + " from slack"
+}
+
+func (ib *inbox) stop() {
+	ib.quit()
+	ib.app.Stop()
+}
+
+func (ib *inbox) showModal(msg string) {
 	modal := tview.NewModal()
 	modal.SetText(msg)
 	modal.AddButtons([]string{"OK"})
 	modal.SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-		app.SetRoot(list, true)
+		ib.app.SetRoot(ib.root, true)
+	})
+	ib.app.SetRoot(modal, false)
+}
+
+// Show msg in the status bar.  Safe to call from any goroutine.
+func (ib *inbox) reportProgress(msg string) {
+	msg = fmt.Sprintf("%s %s", time.Now().Format("15:04:05"), msg)
+	seq := atomic.AddInt32(&ib.progressReported, 1)
+	// queued from a new goroutine, as the ui goroutine may itself be waiting
+	// on the call that's reporting
+	go ib.app.QueueUpdateDraw(func() {
+		if seq < ib.progressShown {
+			return
+		}
+		ib.progressShown = seq
+		ib.status.SetText(tview.Escape(msg))
 	})
-	app.SetRoot(modal, false)
 }
 
-func createSelectFunc(api *SlackBoxAPI, ac AcknowledgedConversation, list *tview.List, app *tview.Application) func() {
+func (ib *inbox) createSelectFunc(ac AcknowledgedConversation) func() {
 	return func() {
 		ts := ac.GetBestLinkableTs()
-		id := ac.ID
+		id := ac.SlackChannelID()
+		api, err := ib.apiFor(ac.Conversation)
+		if err != nil {
+			ib.showModal(fmt.Sprintf("%s", err))
+			return
+		}
 		link, err := api.FetchConversationLink(id, ts)
 		if err == nil {
-			err = browser.OpenURL(link)
+			err = ib.display.openURL(link)
 		}
 		if err != nil {
-			showModal(fmt.Sprintf("%s", err), app, list)
+			ib.showModal(fmt.Sprintf("%s", err))
 		}
 	}
 }
 
-func ackConversation(unackedConversations []AcknowledgedConversation, db *SlackBoxDB, app *tview.Application, list *tview.List) {
-	i := list.GetCurrentItem()
-	uc := unackedConversations[i]
-	id := uc.ID
-	ts := uc.LatestMsgTs
-	err := db.AckConversation(id, ts)
+func (ib *inbox) ackConversation() {
+	uc, ok := ib.selectedConversation()
+	if !ok {
+		return
+	}
+	i := ib.list.GetCurrentItem()
+	err := ib.db.Do(JournalEntry{Action: ackAction, TeamID: uc.TeamID, ConversationID: uc.ID, Ts: uc.LatestMsgTs, Position: i})
 	if err != nil {
-		showModal(fmt.Sprintf("%s", err), app, list)
+		ib.showModal(fmt.Sprintf("%s", err))
 		return
 	}
-	list.SetItemText(i, fmt.Sprintf("  %s", uc.DisplayName), "")
+	delete(ib.arrived, uc.key())
+	ib.list.SetItemText(i, ib.itemText(uc, true), "")
 }
 
-func unackConversation(unackedConversations []AcknowledgedConversation, db *SlackBoxDB, app *tview.Application, list *tview.List) {
-	i := list.GetCurrentItem()
-	uc := unackedConversations[i]
-	id := uc.ID
-	ts := uc.LatestMsgTs
-	err := db.UnackConversation(id, ts)
+func (ib *inbox) unackConversation() {
+	uc, ok := ib.selectedConversation()
+	if !ok {
+		return
+	}
+	i := ib.list.GetCurrentItem()
+	_, err := ib.db.UnackLatest(uc.Conversation, i)
 	if err != nil {
-		showModal(fmt.Sprintf("%s", err), app, list)
+		ib.showModal(fmt.Sprintf("%s", err))
 		return
 	}
-	list.SetItemText(i, fmt.Sprintf("[::b]* %s", uc.DisplayName), "")
+	ib.list.SetItemText(i, ib.itemText(uc, false), "")
 }
 
-func showHelpModal(app *tview.Application, list *tview.List) {
-	help := "Navigate with j/k or arrow keys\nr marks a conversation as read\nu marks a conversation as unread again\nEnter opens the current selection in slack\ng re-fetches conversations from slack\nh or ? brings up this help"
-	showModal(help, app, list)
+func (ib *inbox) showHelpModal() {
+	k := ib.display.keys.names
+	help := fmt.Sprintf("Navigate with %s/%s or arrow keys\n"+
+		"%s marks a conversation as read\n"+
+		"%s marks a conversation as unread again\n"+
+		"%s snoozes a conversation until a time (1h, tomorrow 9am, monday) or a new message\n"+
+		"%s lists snoozed conversations\n"+
+		"%s lists recently read conversations, to mark unread again or open\n"+
+		"%s undoes the last read, unread, snooze or wake, %s redoes it\n"+
+		"%s replies to the conversation, %s replies in a thread off its latest message\n"+
+		"Threads show up as their own conversations, named conversation › first message\n"+
+		"Mentions outside the conversations you track are listed separately, each read on its own\n"+
+		"Enter opens the current selection in slack\n"+
+		"%s re-fetches conversations from slack, %s only from the current conversation's workspace\n"+
+		"Conversations marked + are new or have new messages since you last moved onto them\n"+
+		"%s brings up this help",
+		k(downKey), k(upKey), k(ackKey), k(unackKey), k(snoozeKey), k(snoozedKey), k(recentKey),
+		k(undoKey), k(redoKey), k(replyKey), k(replyThreadKey), k(refreshKey), k(refreshWorkspaceKey), k(helpKey))
+	ib.showModal(help)
 }
 
-func createInputCaptureFunc(unackedConversations []AcknowledgedConversation, api *SlackBoxAPI, db *SlackBoxDB, app *tview.Application, list *tview.List) func(*tcell.EventKey) *tcell.EventKey {
+func (ib *inbox) createInputCaptureFunc() func(*tcell.EventKey) *tcell.EventKey {
 	return func(event *tcell.EventKey) *tcell.EventKey {
-		key := event.Key()
-
-		if key == tcell.KeyRune {
-			ch := event.Rune()
-			switch ch {
-			case 'j':
-				event = tcell.NewEventKey(tcell.KeyDown, ch, event.Modifiers())
-			case 'k':
-				event = tcell.NewEventKey(tcell.KeyUp, ch, event.Modifiers())
-			case 'g':
-				initList(api, db, app)
-				event = nil
-			case 'r':
-				ackConversation(unackedConversations, db, app, list)
-				event = tcell.NewEventKey(tcell.KeyDown, ch, event.Modifiers())
-			case 'u':
-				unackConversation(unackedConversations, db, app, list)
-				event = nil
-			case '?':
-				showHelpModal(app, list)
-				event = nil
-			case 'h':
-				showHelpModal(app, list)
-				event = nil
-			case 'q':
-				app.Stop()
-				event = nil
-			}
+		if event.Key() != tcell.KeyRune {
+			return event
+		}
+
+		ch := event.Rune()
+		action, found := ib.display.actions[ch]
+		if !found {
+			return event
 		}
 
-		return event
+		switch action {
+		case downKey:
+			return tcell.NewEventKey(tcell.KeyDown, ch, event.Modifiers())
+		case upKey:
+			return tcell.NewEventKey(tcell.KeyUp, ch, event.Modifiers())
+		case refreshKey:
+			ib.initList()
+		case refreshWorkspaceKey:
+			ib.refreshSelectedWorkspace()
+		case ackKey:
+			ib.ackConversation()
+			return tcell.NewEventKey(tcell.KeyDown, ch, event.Modifiers())
+		case unackKey:
+			ib.unackConversation()
+		case snoozeKey:
+			ib.showSnooze()
+		case snoozedKey:
+			ib.showSnoozed()
+		case recentKey:
+			ib.showRecent()
+		case undoKey:
+			ib.stepJournal(true)
+		case redoKey:
+			ib.stepJournal(false)
+		case replyKey:
+			ib.showCompose(false)
+		case replyThreadKey:
+			ib.showCompose(true)
+		case helpKey:
+			ib.showHelpModal()
+		case quitKey:
+			ib.stop()
+		}
+
+		return nil
 	}
 }
 
-func initList(api *SlackBoxAPI, db *SlackBoxDB, app *tview.Application) {
-	list := tview.NewList()
+// Show the unacked conversations already in the db, then re-fetch
+// conversations from every workspace in the background, showing the unacked
+// ones again as each is done.  The list stays usable meanwhile.
+func (ib *inbox) initList() {
+	ib.reloadList()
 
-	list.ShowSecondaryText(false)
-	list.SetDoneFunc(func() {
-		app.Stop()
-	})
-	list.SetBorder(true)
-	list.SetTitle(fmt.Sprintf("%s (? or h for help)", api.TeamName()))
+	for _, api := range ib.apis {
+		if ib.refreshing[api.TeamID()] {
+			ib.reportProgress("Still refreshing " + ib.fromSlack(api))
+			continue
+		}
+		ib.startRefresh(api)
+	}
+}
+
+// Re-fetch the selected conversation's workspace from slack in the
+// background, leaving any others be.
+func (ib *inbox) refreshSelectedWorkspace() {
+	uc, ok := ib.selectedConversation()
+	if !ok {
+		return
+	}
+
+	api, err := ib.apiFor(uc.Conversation)
+	if err != nil {
+		ib.showModal(fmt.Sprintf("%s", err))
+		return
+	}
+	if ib.refreshing[api.TeamID()] {
+		ib.reportProgress("Still refreshing " + ib.fromSlack(api))
+		return
+	}
+	ib.startRefresh(api)
+}
+
+// Re-fetch conversations from slack every interval, for as long as the inbox
+// is open, skipping a workspace if its last refresh is still running.
+func (ib *inbox) refreshEvery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ib.ctx.Done():
+				return
+			case <-ticker.C:
+				ib.app.QueueUpdateDraw(func() {
+					for _, api := range ib.apis {
+						if !ib.refreshing[api.TeamID()] {
+							ib.startRefresh(api)
+						}
+					}
+				})
+			}
+		}
+	}()
+}
+
+// Must be called on the ui goroutine, and not while a refresh of the api's
+// workspace is running.
+func (ib *inbox) startRefresh(api SlackAPI) {
+	ib.refreshing[api.TeamID()] = true
+	ib.reportProgress("Refreshing " + ib.fromSlack(api))
+
+	go func() {
+		err := updateFromSlack(ib.ctx, api, ib.db, ib.refresh)
+		if ib.ctx.Err() != nil {
+			// quitting, so there's nothing left to show
+			return
+		}
 
-	app.SetRoot(list, true)
+		ib.app.QueueUpdateDraw(func() {
+			delete(ib.refreshing, api.TeamID())
+			// read from the db now rather than in the background, so acks
+			// made during the refresh aren't undone on screen
+			ib.reloadWithArrivals()
+			if err != nil {
+				ib.showModal(fmt.Sprintf("%s%s", ib.teamPrefix(api), err))
+				return
+			}
+			ib.reportProgress("Refreshed " + ib.fromSlack(api))
+		})
+	}()
+}
 
-	unackedConversations, err := updateAndFindUnacked(api, db)
+// Show the unacked conversations already in the db, without going to slack.
+func (ib *inbox) reloadList() {
+	unackedConversations, err := ib.db.GetUnackedConversations()
 	if err != nil {
-		showModal(fmt.Sprintf("%s", err), app, list)
+		ib.showModal(fmt.Sprintf("%s", err))
+		return
+	}
+	ib.showUnacked(unackedConversations)
+}
+
+// Show the unacked conversations in the db like reloadList, marking those
+// that are new or have new messages since the list was last shown.
+func (ib *inbox) reloadWithArrivals() {
+	shown := make(map[conversationKey]string)
+	for _, uc := range ib.unacked {
+		shown[uc.key()] = uc.LatestMsgTs
+	}
+
+	ib.reloadList()
+
+	for i, uc := range ib.unacked {
+		latestMsgTs, found := shown[uc.key()]
+		if uc.ID != "" && (!found || uc.LatestMsgTs > latestMsgTs) {
+			ib.arrived[uc.key()] = true
+			ib.list.SetItemText(i, ib.itemText(uc, false), "")
+		}
+	}
+}
+
+// Stop marking the selected conversation as newly arrived.
+func (ib *inbox) markSeen() {
+	uc, ok := ib.selectedConversation()
+	if !ok || !ib.arrived[uc.key()] {
+		return
+	}
+
+	delete(ib.arrived, uc.key())
+	ib.list.SetItemText(ib.list.GetCurrentItem(), ib.itemText(uc, false), "")
+}
+
+// The conversation's row in the list, tagged with its workspace if there's
+// more than one.
+func (ib *inbox) itemText(uc AcknowledgedConversation, acked bool) string {
+	name := tview.Escape(uc.DisplayName)
+	if api, err := ib.apiFor(uc.Conversation); err == nil && len(ib.apis) > 1 {
+		name = fmt.Sprintf("[%s]%s[-] %s", tagColor(ib.display.theme.Muted), tview.Escape(api.TeamName()), name)
+	}
+
+	switch {
+	case acked:
+		return fmt.Sprintf("  %s", name)
+	case ib.arrived[uc.key()]:
+		return fmt.Sprintf("[%s::b]+ %s[-::-]", tagColor(ib.display.theme.Arrived), name)
+	default:
+		return fmt.Sprintf("[::b]* %s", name)
 	}
+}
 
-	list.SetInputCapture(createInputCaptureFunc(unackedConversations, api, db, app, list))
+// Replace the contents of the list, keeping the same conversation selected if
+// it's still there.  Mentions follow the conversations, under a header of
+// their own.
+func (ib *inbox) showUnacked(unackedConversations []AcknowledgedConversation) {
+	var selectedKey conversationKey
+	selected := ib.list.GetCurrentItem()
+	if selected < len(ib.unacked) {
+		selectedKey = ib.unacked[selected].key()
+	}
 
+	conversations := make([]AcknowledgedConversation, 0, len(unackedConversations))
+	mentions := make([]AcknowledgedConversation, 0)
 	for _, uc := range unackedConversations {
-		list.AddItem(fmt.Sprintf("[::b]* %s", uc.DisplayName), "", 0, createSelectFunc(api, uc, list, app))
+		// stored from a workspace that's no longer configured, so there's
+		// nothing to open or reply to it through
+		if _, err := ib.apiFor(uc.Conversation); err != nil {
+			continue
+		}
+
+		if uc.ConversationType == "mention" {
+			mentions = append(mentions, uc)
+		} else {
+			conversations = append(conversations, uc)
+		}
+	}
+
+	ib.rebuilding = true
+	defer func() {
+		ib.rebuilding = false
+	}()
+
+	ib.list.Clear()
+	ib.unacked = conversations
+
+	if len(mentions) > 0 {
+		// the header is a blank item, so it can't be acked or opened
+		ib.unacked = append(ib.unacked, AcknowledgedConversation{})
+		ib.unacked = append(ib.unacked, mentions...)
 	}
+
+	// a conversation that's been read will have a new key by the time
+	// it's unread again, so its preview is no use
+	listed := make(map[conversationKey]bool, len(ib.unacked))
+	for _, uc := range ib.unacked {
+		listed[uc.key()] = true
+	}
+	for key := range ib.previews {
+		if !listed[key] {
+			delete(ib.previews, key)
+		}
+	}
+
+	for i, uc := range ib.unacked {
+		if uc.ID == "" {
+			ib.list.AddItem(fmt.Sprintf("[%s]── Mentions ──[-]", tagColor(ib.display.theme.Muted)), "", 0, nil)
+			continue
+		}
+
+		ib.list.AddItem(ib.itemText(uc, false), "", 0, ib.createSelectFunc(uc))
+		if uc.key() == selectedKey {
+			selected = i
+		}
+	}
+
+	if selected >= len(ib.unacked) {
+		selected = len(ib.unacked) - 1
+	}
+	if selected >= 0 {
+		ib.list.SetCurrentItem(selected)
+	}
+
+	ib.showPreview()
+}
+
+// Follow slack's event stream in the background, redrawing the list as
+// messages arrive.
+func (ib *inbox) streamEvents(api *SlackBoxAPI) *EventStream {
+	stream := api.NewEventStream(
+		ib.db,
+		func(Conversation) {
+			ib.app.QueueUpdateDraw(ib.reloadWithArrivals)
+		},
+		func(err error) {
+			ib.app.QueueUpdateDraw(func() {
+				ib.showModal(fmt.Sprintf("%s", err))
+			})
+		})
+	go stream.Run()
+	return stream
+}
+
+func mustLoadSettings(configPath string, profile string) settings {
+	s, err := loadSettings(configPath, profile)
+
+	if err != nil {
+		log.Fatalf("Error loading config: %s", err)
+	}
+
+	return s
 }
 
 func main() {
-	tokenPath := flag.String("tokenpath", "tokenfile.txt", "The path containing your slack token")
-	dbPath := flag.String("dbpath", "slackbox.db", "The path to the message db")
+	defaults := defaultSettings()
+	configPath := flag.String("config", "", "The config file to read settings from (default "+defaultConfigPath()+")")
+	profile := flag.String("profile", "", "The profile in the config file to use, rather than the one it chooses")
+	tokenPaths := flag.String("tokenpath", strings.Join(defaults.TokenPaths, ","), "Comma-separated places to read your slack tokens from, one for each workspace: each "+tokenSourceUsage)
+	dbPath := flag.String("dbpath", defaults.DBPath, "The path to the message db")
+	includeChannels := flag.String("channels", "", "Comma-separated channel names or IDs to track, or all for every channel you're a member of")
+	excludeChannels := flag.String("exclude-channels", "", "Comma-separated channel names or IDs never to track")
+	workers := flag.Int("workers", defaults.Workers, "How many conversations to fetch from slack at once")
+	userTTL := flag.Duration("user-ttl", defaults.UserTTL.Duration, "How long to trust the stored user directory before fetching it again")
+	threads := flag.Bool("threads", defaults.Threads, "Track threads you start or reply in, and threads in DMs, as conversations of their own")
+	threadLookback := flag.Duration("thread-lookback", defaults.ThreadLookback.Duration, "How long to keep checking a quiet thread, or a message for new threads off it, for replies")
+	mentions := flag.Bool("mentions", defaults.Mentions, "Search for messages mentioning you outside the conversations you track")
+	refreshInterval := flag.Duration("refresh-interval", defaults.RefreshInterval.Duration, "How often to re-fetch conversations from slack in the background, or 0 to only fetch on start and with g")
+	realtime := flag.Bool("realtime", defaults.Realtime, "Update the inbox as messages arrive, rather than only on refresh")
+	ackHistory := flag.Int("ack-history", defaults.AckHistory, "How many acknowledgements to keep per conversation, for marking it unread again")
+	browserCommand := flag.String("browser", defaults.Browser, "The command to open links with, given the link as its last argument or in place of a %s, rather than the default browser")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n%s\n", commandUsage)
+	}
 	flag.Parse()
 
-	token := mustHaveToken(*tokenPath)
-	api := mustConnectAPI(token)
-	db := mustConnectDB(*dbPath)
+	// flags given explicitly override the config file
+	cfg := mustLoadSettings(*configPath, *profile)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tokenpath":
+			cfg.TokenPaths = splitTokenPaths(*tokenPaths)
+		case "dbpath":
+			cfg.DBPath = *dbPath
+		case "channels":
+			cfg.Channels = strings.Split(*includeChannels, ",")
+		case "exclude-channels":
+			cfg.ExcludeChannels = strings.Split(*excludeChannels, ",")
+		case "workers":
+			cfg.Workers = *workers
+		case "user-ttl":
+			cfg.UserTTL.Duration = *userTTL
+		case "threads":
+			cfg.Threads = *threads
+		case "thread-lookback":
+			cfg.ThreadLookback.Duration = *threadLookback
+		case "mentions":
+			cfg.Mentions = *mentions
+		case "refresh-interval":
+			cfg.RefreshInterval.Duration = *refreshInterval
+		case "realtime":
+			cfg.Realtime = *realtime
+		case "ack-history":
+			cfg.AckHistory = *ackHistory
+		case "browser":
+			cfg.Browser = *browserCommand
+		}
+	})
+
+	// checked and expanded again, as the config file's values were
+	err := cfg.finish()
+	if err != nil {
+		log.Fatalf("Error in flags: %s", err)
+	}
+
+	apiOpts := APIOptions{
+		Channels:       NewChannelFilter(strings.Join(cfg.Channels, ","), strings.Join(cfg.ExcludeChannels, ",")),
+		Threads:        cfg.Threads,
+		ThreadLookback: cfg.ThreadLookback.Duration,
+		Mentions:       cfg.Mentions,
+		Realtime:       cfg.Realtime,
+		Workers:        cfg.Workers,
+	}
+	refresh := refreshOptions{userTTL: cfg.UserTTL.Duration, threadLookback: cfg.ThreadLookback.Duration}
+	openURL := cfg.openURL(browser.OpenURL)
+
+	if flag.NArg() > 0 {
+		env := &commandEnv{
+			settings:   cfg,
+			refresh:    refresh,
+			openURL:    openURL,
+			readSecret: readTerminalSecret,
+			slackURL:   "https://slack.com/",
+			out:        os.Stdout,
+		}
+
+		switch flag.Arg(0) {
+		case "config", "token", "login":
+			// these shouldn't make a db, or need the tokens already
+		default:
+			env.db = mustConnectDB(cfg.DBPath)
+			env.db.SetAckHistory(cfg.AckHistory)
+			env.connectAPIs = func() ([]SlackAPI, error) {
+				apis, err := connectWorkspaces(cfg.TokenPaths, apiOpts, env.db)
+				return toSlackAPIs(apis), err
+			}
+		}
+
+		silenceBrowserOutput()
+		err := runCommand(flag.Args(), env)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db := mustConnectDB(cfg.DBPath)
+	db.SetAckHistory(cfg.AckHistory)
+	apis := mustConnectWorkspaces(cfg.TokenPaths, apiOpts, db)
 
 	silenceBrowserOutput()
+	cfg.Theme.apply()
+
+	actions, err := cfg.Keys.actions()
+	if err != nil {
+		log.Fatalf("Error binding keys: %s", err)
+	}
 
 	app := tview.NewApplication()
-	initList(api, db, app)
+	ib := newInbox(toSlackAPIs(apis), db, refresh, displayOptions{keys: cfg.Keys, actions: actions, theme: cfg.Theme, openURL: openURL}, app)
+	ib.initList()
 
-	if err := app.Run(); err != nil {
+	if cfg.RefreshInterval.Duration > 0 {
+		ib.refreshEvery(cfg.RefreshInterval.Duration)
+	}
+
+	// workspaces whose tokens can't use RTM were turned off when connecting
+	for _, api := range apis {
+		if api.realtime {
+			stream := ib.streamEvents(api)
+			defer stream.Stop()
+		}
+	}
+
+	err = app.Run()
+	ib.quit()
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+func TestUpdateFromSlack(t *testing.T) {
+	db := memoryDB(t)
+	fake := newFakeSlack()
+	opts := refreshOptions{userTTL: time.Hour, threadLookback: 7 * 24 * time.Hour}
+
+	parent := Conversation{ID: "D1", ConversationType: "im", DisplayName: "alice", LatestMsgTs: "1.0", TeamID: fake.teamID}
+	recent := newThread(parent, "1.0")
+	recent.DisplayName = "alice › recent"
+	recent.LatestMsgTs = timeToSlackTs(time.Now().Add(-24 * time.Hour))
+	quiet := newThread(parent, "0.5")
+	quiet.DisplayName = "alice › quiet"
+	quiet.LatestMsgTs = "0.6"
+	checkUpdate(t, db, parent)
+	checkUpdate(t, db, recent)
+	checkUpdate(t, db, quiet)
+
+	fake.users = []User{{ID: "U1", RealName: "Alice A", DisplayName: "alice"}}
+	fake.conversations = []Conversation{{ID: "D1", ConversationType: "im", DisplayName: "alice", LatestMsgTs: "5.0", TeamID: fake.teamID}}
+	updatedRecent := recent
+	updatedRecent.LatestMsgTs = timeToSlackTs(time.Now())
+	fake.threadUpdates[recent.ID] = updatedRecent
+	mention := Conversation{ID: mentionID("C1", "7.0"), ConversationType: "mention", DisplayName: "bob in #general › hi", LatestMsgTs: "7.0", TeamID: fake.teamID, ChannelID: "C1"}
+	fake.mentions = []Conversation{mention}
+
+	err := updateFromSlack(context.Background(), fake, db, opts)
+	if err != nil {
+		t.Fatalf("updateFromSlack failed with error %s", err)
+	}
+
+	users, err := db.GetUsers(fake.teamID)
+	if err != nil {
+		t.Fatalf("GetUsers failed with error %s", err)
+	}
+	if len(users) != 1 || !reflect.DeepEqual(users, fake.userNames) {
+		t.Errorf("Expected the stored users to be given to the api, got %v and %v", users, fake.userNames)
+	}
+
+	if fake.fetchedSince["D1"] != "1.0" {
+		t.Errorf("Expected conversations fetched since 1.0, got %v", fake.fetchedSince)
+	}
+
+	// only threads with recent replies are refreshed
+	if !reflect.DeepEqual(fake.fetchedThreads, []string{recent.ID}) {
+		t.Errorf("Expected only %s refreshed, got %v", recent.ID, fake.fetchedThreads)
+	}
+
+	// timestamps sort as text, so the small ones here sort around the real one
+	checkUnacked(t, db, []Conversation{mention, fake.conversations[0], updatedRecent, quiet})
+
+	if fake.fetchedMentionsFor != "" {
+		t.Errorf("Expected mentions fetched from the start, got since %s", fake.fetchedMentionsFor)
+	}
+
+	err = updateFromSlack(context.Background(), fake, db, opts)
+	if err != nil {
+		t.Fatalf("updateFromSlack failed with error %s", err)
+	}
+
+	if fake.usersFetched != 1 {
+		t.Errorf("Expected the user directory to be fetched once, got %d", fake.usersFetched)
+	}
+	if fake.fetchedMentionsFor != mention.LatestMsgTs {
+		t.Errorf("Expected mentions fetched since %s, got %s", mention.LatestMsgTs, fake.fetchedMentionsFor)
+	}
+}
+
+func TestUpdateFromSlackWorkspaces(t *testing.T) {
+	db := memoryDB(t)
+	opts := refreshOptions{userTTL: time.Hour, threadLookback: 7 * 24 * time.Hour}
+
+	work := newFakeSlack()
+	work.teamID, work.teamName = "T1", "work"
+	work.users = []User{{ID: "U1", RealName: "Alice"}}
+	work.conversations = []Conversation{{ID: "D1", ConversationType: "im", DisplayName: "Alice", LatestMsgTs: "2.0", TeamID: "T1"}}
+	work.mentions = []Conversation{{ID: mentionID("C1", "3.0"), ConversationType: "mention", DisplayName: "alice in #general › hi", LatestMsgTs: "3.0", TeamID: "T1", ChannelID: "C1"}}
+
+	club := newFakeSlack()
+	club.teamID, club.teamName = "T2", "club"
+	club.users = []User{{ID: "U2", RealName: "Bob"}}
+	club.conversations = []Conversation{{ID: "D2", ConversationType: "im", DisplayName: "Bob", LatestMsgTs: "1.0", TeamID: "T2"}}
+
+	for _, fake := range []*fakeSlack{work, club, club} {
+		err := updateFromSlack(context.Background(), fake, db, opts)
+		if err != nil {
+			t.Fatalf("updateFromSlack failed with error %s", err)
+		}
+	}
+
+	checkUnacked(t, db, []Conversation{work.mentions[0], work.conversations[0], club.conversations[0]})
+
+	// each workspace only hears about its own conversations, users and
+	// mentions
+	if !reflect.DeepEqual(club.fetchedSince, map[string]string{"D2": "1.0"}) {
+		t.Errorf("Expected club to fetch only its own conversations, got %v", club.fetchedSince)
+	}
+	if len(club.userNames) != 1 || club.UserName("U2") != "Bob" {
+		t.Errorf("Expected club to be given only its own users, got %v", club.userNames)
+	}
+	if club.fetchedMentionsFor != "" {
+		t.Errorf("Expected club to fetch mentions from the start, got since %s", club.fetchedMentionsFor)
+	}
+	if club.usersFetched != 1 {
+		t.Errorf("Expected club's directory fetched once, got %d", club.usersFetched)
+	}
+}
+
+func TestAPIForTeam(t *testing.T) {
+	work := newFakeSlack()
+	work.teamID = "T1"
+	club := newFakeSlack()
+	club.teamID = "T2"
+	apis := []SlackAPI{work, club}
+
+	api, err := apiForTeam(apis, "T2")
+	if err != nil {
+		t.Fatalf("apiForTeam failed with error %s", err)
+	}
+	if api != club {
+		t.Errorf("Expected the api for T2, got %s", api.TeamID())
+	}
+
+	// a workspace that's no longer configured, or none at all, isn't
+	// reached through another
+	for _, apis := range [][]SlackAPI{apis, nil} {
+		_, err = apiForTeam(apis, "T3")
+		if err == nil {
+			t.Errorf("Expected an error finding T3 in %d workspaces", len(apis))
+		}
+	}
+}
+
+func TestUpdateFromSlackError(t *testing.T) {
+	db := memoryDB(t)
+	fake := newFakeSlack()
+	fake.err = errors.New("slack is down")
+
+	err := updateFromSlack(context.Background(), fake, db, refreshOptions{})
+	if err != fake.err {
+		t.Errorf("Expected error %s, got %v", fake.err, err)
+	}
+}
+
+// An inbox on the fake workspace, as the TUI builds it, without running it.
+func testInbox(db *SlackBoxDB, api SlackAPI) *inbox {
+	return newInbox([]SlackAPI{api}, db, refreshOptions{}, displayOptions{theme: defaultTheme()}, tview.NewApplication())
+}
+
+// Move the selection as the arrow keys do.
+func pressKey(ib *inbox, key tcell.Key) {
+	ib.list.InputHandler()(tcell.NewEventKey(key, 0, tcell.ModNone), func(tview.Primitive) {})
+}
+
+// The ids of the listed conversations, and the id of the selected one.
+func listed(ib *inbox) ([]string, string) {
+	ids := make([]string, 0, len(ib.unacked))
+	for _, uc := range ib.unacked {
+		ids = append(ids, uc.ID)
+	}
+	selected, _ := ib.selectedConversation()
+	return ids, selected.ID
+}
+
+func TestReloadMarksArrivals(t *testing.T) {
+	db := memoryDB(t)
+	api := newFakeSlack()
+	checkUpdate(t, db, Conversation{ID: "D1", ConversationType: "im", DisplayName: "alice", LatestMsgTs: "3.0", TeamID: api.teamID})
+	checkUpdate(t, db, Conversation{ID: "C1", ConversationType: "channel", DisplayName: "#general", LatestMsgTs: "2.0", TeamID: api.teamID})
+	checkUpdate(t, db, Conversation{ID: "C2", ConversationType: "channel", DisplayName: "#random", LatestMsgTs: "1.0", TeamID: api.teamID})
+
+	ib := testInbox(db, api)
+	ib.reloadList()
+	pressKey(ib, tcell.KeyDown)
+	if _, selected := listed(ib); selected != "C1" {
+		t.Fatalf("Expected C1 selected, got %s", selected)
+	}
+
+	// a new conversation and a new message in another, as a refresh finds
+	checkUpdate(t, db, Conversation{ID: "D2", ConversationType: "im", DisplayName: "bob", LatestMsgTs: "4.0", TeamID: api.teamID})
+	checkUpdate(t, db, Conversation{ID: "C2", ConversationType: "channel", DisplayName: "#random", LatestMsgTs: "5.0", TeamID: api.teamID})
+	ib.reloadWithArrivals()
+
+	ids, selected := listed(ib)
+	if expected := []string{"C2", "D2", "D1", "C1"}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("Expected %v listed, got %v", expected, ids)
+	}
+	if selected != "C1" {
+		t.Errorf("Expected C1 still selected after moving down the list, got %s", selected)
+	}
+
+	checkMarks := func(expected ...string) {
+		for i, uc := range ib.unacked {
+			text, _ := ib.list.GetItemText(i)
+			arrived := strings.Contains(text, "+ "+uc.DisplayName)
+			if arrived != (expected[i] == "+") {
+				t.Errorf("Expected %s marked %s, got %q", uc.ID, expected[i], text)
+			}
+		}
+	}
+	checkMarks("+", "+", "*", "*")
+
+	// moving onto a conversation is seeing it
+	pressKey(ib, tcell.KeyUp)
+	pressKey(ib, tcell.KeyUp)
+	if _, selected := listed(ib); selected != "D2" {
+		t.Fatalf("Expected D2 selected, got %s", selected)
+	}
+	checkMarks("+", "*", "*", "*")
+
+	// nothing new since, so nothing more is marked
+	ib.reloadWithArrivals()
+	checkMarks("+", "*", "*", "*")
+	if _, selected := listed(ib); selected != "D2" {
+		t.Errorf("Expected D2 still selected, got %s", selected)
+	}
+}
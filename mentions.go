@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// How far back to look for mentions the first time they're fetched.
+const mentionLookback = 7 * 24 * time.Hour
+
+// How many search results to ask slack for at once.
+const mentionPageSize = 100
+
+// Each mention is an inbox item of its own, under an id made from the
+// channel and the ts of the mentioning message.
+func mentionID(channelID string, ts string) string {
+	return "mention/" + channelID + "/" + ts
+}
+
+// Search results don't say which thread a message is in, but their
+// permalinks do.
+func permalinkThreadTs(permalink string, ts string) string {
+	link, err := url.Parse(permalink)
+	if err != nil {
+		return ""
+	}
+
+	threadTs := link.Query().Get("thread_ts")
+	if threadTs == ts {
+		return ""
+	}
+	return threadTs
+}
+
+// A mention we'd see anyway, in a conversation or thread we track, isn't
+// worth an item of its own.
+func mentionIsTracked(match slack.SearchMessage, threadTs string, tracked map[string]string) bool {
+	if threadTs != "" {
+		_, found := tracked[threadID(match.Channel.ID, threadTs)]
+		return found
+	}
+	_, found := tracked[match.Channel.ID]
+	return found
+}
+
+// Name the mention after who wrote it and where, naming DMs and group DMs as
+// the inbox does: search only has their ids to name them by.
+func (api *SlackBoxAPI) toMention(ctx context.Context, match slack.SearchMessage, threadTs string) (Conversation, error) {
+	author := match.Username
+	if match.User != "" {
+		author = api.UserName(match.User)
+	}
+
+	name := fmt.Sprintf("%s in #%s", author, match.Channel.Name)
+	switch {
+	case match.Channel.IsMPIM || strings.HasPrefix(match.Channel.Name, "mpdm-"):
+		mpim, err := api.mpimToConversation(ctx, match.Channel.ID)
+		if err != nil {
+			return Conversation{}, err
+		}
+		name = fmt.Sprintf("%s in %s", author, mpim.DisplayName)
+	case strings.HasPrefix(match.Channel.ID, "D"):
+		// search names a DM by the other user's id, and they're the only
+		// one who could be mentioning us in it
+		im, err := api.imToConversation(ctx, match.Channel.ID, match.Channel.Name)
+		if err != nil {
+			return Conversation{}, err
+		}
+		name = im.DisplayName
+	}
+
+	return Conversation{
+		ID:               mentionID(match.Channel.ID, match.Timestamp),
+		ConversationType: "mention",
+		DisplayName:      name + " › " + summarizeText(match.Text, api.UserName),
+		LatestMsgTs:      match.Timestamp,
+		TeamID:           api.teamID,
+		ChannelID:        match.Channel.ID,
+		ThreadTs:         threadTs,
+	}, nil
+}
+
+// Search for messages mentioning us since the since ts (or mentionLookback
+// ago, if that's more recent), skipping those in the tracked conversations
+// and threads.  Returns nothing if we aren't fetching mentions.
+func (api *SlackBoxAPI) FetchMentions(ctx context.Context, since string, tracked map[string]string) ([]Conversation, error) {
+	mentions := make([]Conversation, 0)
+
+	if !api.mentions {
+		return mentions, nil
+	}
+
+	cutoff := time.Now().Add(-mentionLookback)
+	if since < timeToSlackTs(cutoff) {
+		since = timeToSlackTs(cutoff)
+	} else {
+		cutoff = slackTsToTime(since)
+	}
+
+	// search only narrows by day, and after: excludes the day given
+	query := fmt.Sprintf("<@%s> after:%s", api.userID, cutoff.AddDate(0, 0, -1).Format("2006-01-02"))
+	params := slack.SearchParameters{Sort: "timestamp", SortDirection: "desc", Count: mentionPageSize, Page: 1}
+
+	for {
+		var results *slack.SearchMessages
+		err := api.limiter.call(ctx, "search.messages", func() (err error) {
+			results, err = api.client.SearchMessagesContext(ctx, query, params)
+			return err
+		})
+		if err != nil && err.Error() == "missing_scope" {
+			// some legacy tokens don't say what scopes they have until
+			// they're used
+			api.mentions = false
+			api.limiter.progress("Skipping mentions, which need the search:read scope")
+			return mentions, nil
+		}
+		if err != nil {
+			return mentions, err
+		}
+
+		for _, match := range results.Matches {
+			if match.Timestamp <= since {
+				return mentions, nil
+			}
+
+			if match.User == api.userID {
+				continue
+			}
+
+			threadTs := permalinkThreadTs(match.Permalink, match.Timestamp)
+			if mentionIsTracked(match, threadTs, tracked) {
+				continue
+			}
+
+			mention, err := api.toMention(ctx, match, threadTs)
+			if err != nil {
+				return mentions, err
+			}
+			mentions = append(mentions, mention)
+		}
+
+		if params.Page >= results.Paging.Pages || len(results.Matches) == 0 {
+			return mentions, nil
+		}
+
+		params.Page++
+	}
+}
+
+// Fetch the single message with the given ts, from the thread if threadTs
+// isn't blank.
+func (api *SlackBoxAPI) FetchMessage(ctx context.Context, channelID string, threadTs string, ts string) (Message, error) {
+	var msgs []slack.Message
+	var err error
+
+	if threadTs != "" {
+		params := &slack.GetConversationRepliesParameters{ChannelID: channelID, Timestamp: threadTs, Oldest: ts, Latest: ts, Inclusive: true}
+		err = api.limiter.call(ctx, "conversations.replies", func() (err error) {
+			msgs, _, _, err = api.client.GetConversationRepliesContext(ctx, params)
+			return err
+		})
+	} else {
+		params := &slack.GetConversationHistoryParameters{ChannelID: channelID, Oldest: ts, Latest: ts, Inclusive: true, Limit: 1}
+		var history *slack.GetConversationHistoryResponse
+		err = api.limiter.call(ctx, "conversations.history", func() (err error) {
+			history, err = api.client.GetConversationHistoryContext(ctx, params)
+			return err
+		})
+		if err == nil {
+			msgs = history.Messages
+		}
+	}
+
+	if err != nil {
+		return Message{}, err
+	}
+
+	for _, msg := range msgs {
+		if msg.Timestamp == ts {
+			author, err := api.messageAuthor(ctx, msg)
+			if err != nil {
+				return Message{}, err
+			}
+			return Message{Ts: msg.Timestamp, UserID: msg.User, Author: author, Text: msg.Text}, nil
+		}
+	}
+
+	return Message{}, fmt.Errorf("Message %s not found in %s", ts, channelID)
+}
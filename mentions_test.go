@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestPermalinkThreadTs(t *testing.T) {
+	cases := []struct {
+		permalink string
+		expected  string
+	}{
+		{"https://example.slack.com/archives/C1/p2000000", ""},
+		{"https://example.slack.com/archives/C1/p2000000?thread_ts=1.000000&cid=C1", "1.000000"},
+		// the first message of a thread isn't in it as far as we're concerned
+		{"https://example.slack.com/archives/C1/p2000000?thread_ts=2.000000&cid=C1", ""},
+		{"%", ""},
+	}
+
+	for _, c := range cases {
+		actual := permalinkThreadTs(c.permalink, "2.000000")
+		if actual != c.expected {
+			t.Errorf("For %s expected thread ts %q, got %q", c.permalink, c.expected, actual)
+		}
+	}
+}
+
+func TestMentionIsTracked(t *testing.T) {
+	tracked := map[string]string{"C1": "5.0", threadID("C2", "1.0"): "4.0"}
+
+	match := slack.SearchMessage{Channel: slack.CtxChannel{ID: "C1"}, Timestamp: "3.0"}
+	if !mentionIsTracked(match, "", tracked) {
+		t.Error("Mention in a tracked channel not treated as tracked")
+	}
+	if mentionIsTracked(match, "2.0", tracked) {
+		t.Error("Mention in an untracked thread treated as tracked")
+	}
+
+	match.Channel.ID = "C2"
+	if mentionIsTracked(match, "", tracked) {
+		t.Error("Mention in an untracked channel treated as tracked")
+	}
+	if !mentionIsTracked(match, "1.0", tracked) {
+		t.Error("Mention in a tracked thread not treated as tracked")
+	}
+}
+
+func searchMatch(channel map[string]interface{}, user string, text string, ts string) map[string]interface{} {
+	return map[string]interface{}{
+		"channel":   channel,
+		"user":      user,
+		"text":      text,
+		"ts":        ts,
+		"permalink": "https://example.slack.com/archives/" + channel["id"].(string) + "/p1",
+	}
+}
+
+func TestFetchMentionsNamesWhereTheyAre(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	ts := timeToSlackTs(time.Now().Add(-time.Hour))
+	s.handle("search.messages", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{"messages": map[string]interface{}{
+			"matches": []interface{}{
+				searchMatch(map[string]interface{}{"id": "C1", "name": "general"}, "U1", "hi <@UME>", ts),
+				// search names DMs and group DMs by ids
+				searchMatch(map[string]interface{}{"id": "D2", "name": "U2", "is_private": true}, "U2", "hey <@UME>", ts),
+				searchMatch(map[string]interface{}{"id": "G1", "name": "mpdm-alice--bob--me-1", "is_mpim": true}, "U1", "yo <@UME>", ts),
+			},
+			"paging": map[string]interface{}{"page": 1, "pages": 1},
+		}})
+	})
+	s.handle("users.info", func(form url.Values) interface{} {
+		names := map[string]string{"U1": "Alice", "U2": "Bob"}
+		return ok(map[string]interface{}{"user": fakeUser(form.Get("user"), names[form.Get("user")])})
+	})
+	s.handle("conversations.members", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{"members": []string{"U1", "U2", "UME"}, "response_metadata": nextCursor("")})
+	})
+
+	api := s.api(APIOptions{Mentions: true})
+	api.SetUsers(map[string]User{"U1": {ID: "U1", RealName: "Alice"}, "U2": {ID: "U2", RealName: "Bob"}})
+	mentions, err := api.FetchMentions(context.Background(), "", map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchMentions failed with error %s", err)
+	}
+
+	names := make([]string, 0)
+	for _, mention := range mentions {
+		names = append(names, mention.DisplayName)
+	}
+	expected := []string{"Alice in #general › hi @UME", "Bob › hey @UME", "Alice in Alice, Bob › yo @UME"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected mentions named %v, got %v", expected, names)
+	}
+}
+
+func TestFetchMentionsSkippedWithoutScope(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("search.messages", func(form url.Values) interface{} {
+		return map[string]interface{}{"ok": false, "error": "missing_scope"}
+	})
+
+	var progress []string
+	api := s.api(APIOptions{Mentions: true})
+	api.OnProgress(func(msg string) {
+		progress = append(progress, msg)
+	})
+
+	for i := 0; i < 2; i++ {
+		mentions, err := api.FetchMentions(context.Background(), "", map[string]string{})
+		if err != nil || len(mentions) != 0 {
+			t.Errorf("Expected no mentions and no error, got %v %v", mentions, err)
+		}
+	}
+	if len(s.callsTo("search.messages")) != 1 {
+		t.Errorf("Expected mentions given up on after 1 search, got %d", len(s.callsTo("search.messages")))
+	}
+	if len(progress) != 1 || !strings.Contains(progress[0], "search:read") {
+		t.Errorf("Expected a warning about search:read, got %v", progress)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+type migration struct {
+	version int
+	sql     string
+}
+
+// The schema, as the ordered list of changes that take a db from one version
+// to the next.  Each is applied in its own transaction.  Never change a
+// migration that has been released--add a new one (and bump
+// SupportedDBVersion) instead.
+var migrations = []migration{
+	{
+		version: 1,
+		sql: `
+      -- the list of conversations we're tracking
+      create table if not exists conversations (
+        -- we use the im/channel id directly from the slack api, which is text
+        id text not null primary key,
+        -- one of 'im', 'mpim' or 'channel'
+        conversation_type text not null,
+        display_name text not null,
+        -- the slack api uses text timestamps
+        latest_msg_ts text
+      );
+
+      create table if not exists acknowledgements (
+        conversation_id text not null,
+        -- slack ts indicating that conversation has been acknowledged up to
+        -- and including this msg
+        acknowledged_through_ts text not null,
+        -- seconds since the epoch, db time when ack was made (*not* slack ts)
+        acknowledged_at int
+      );
+
+      create unique index if not exists ack_convo_idx on acknowledgements (
+        conversation_id, acknowledged_through_ts);
+    `,
+	},
+	{
+		version: 2,
+		sql: `
+      -- a local copy of the team's user directory, so names can be resolved
+      -- without asking slack every time
+      create table if not exists users (
+        id text not null primary key,
+        real_name text not null,
+        display_name text not null,
+        is_bot int not null,
+        deleted int not null,
+        -- seconds since the epoch, db time when the user was fetched
+        fetched_at int not null
+      );
+    `,
+	},
+	{
+		version: 3,
+		sql: `
+      -- threads are tracked as conversations too, identified by the channel
+      -- they're in and the ts of the message that started them.  Both are
+      -- blank for everything else.
+      alter table conversations add column channel_id text not null default '';
+      alter table conversations add column thread_ts text not null default '';
+    `,
+	},
+	{
+		version: 4,
+		sql: `
+      -- conversations hidden from the inbox for now, at most one snooze each
+      create table if not exists snoozes (
+        conversation_id text not null primary key,
+        -- the conversation's latest_msg_ts when it was snoozed
+        snoozed_through_ts text not null,
+        -- seconds since the epoch when the snooze ends, or null if it only
+        -- ends when a message arrives after snoozed_through_ts
+        snoozed_until int,
+        -- seconds since the epoch, db time when the snooze was made
+        snoozed_at int not null
+      );
+    `,
+	},
+	{
+		version: 5,
+		sql: `
+      -- the actions taken in the inbox, oldest first, for undo and redo
+      create table if not exists journal (
+        id integer primary key autoincrement,
+        -- one of 'ack', 'unack', 'snooze' or 'unsnooze'
+        action text not null,
+        conversation_id text not null,
+        -- the ts acked or unacked through, or snoozed through
+        ts text not null,
+        -- when a snooze ends, null if it lasts until a new message
+        snoozed_until int,
+        -- the snooze the conversation had before a snooze or unsnooze,
+        -- if any, to put back on undo
+        prev_snoozed_through_ts text,
+        prev_snoozed_until int,
+        -- the row of the inbox list the conversation was on
+        position int not null,
+        -- 1 once the action has been undone, until it's redone
+        undone int not null default 0,
+        -- seconds since the epoch, db time when the action was taken
+        done_at int not null
+      );
+    `,
+	},
+	{
+		version: 6,
+		sql: `
+      -- the workspace each conversation and user is in, so several can share
+      -- the db.  Blank for rows stored while slackbox followed a single
+      -- workspace, until ClaimUnowned gives them to it.
+      alter table conversations add column team_id text not null default '';
+      alter table users add column team_id text not null default '';
+    `,
+	},
+	{
+		version: 7,
+		sql: `
+      -- whether the conversation already had the ack an ack or unack action
+      -- is for, so undoing an ack only takes back one it made
+      alter table journal add column had_ack int not null default 0;
+
+      -- the acks an action deleted, whether unacked or trimmed, to put back
+      -- on undo
+      create table if not exists journal_removed_acks (
+        journal_id int not null,
+        conversation_id text not null,
+        acknowledged_through_ts text not null,
+        acknowledged_at int
+      );
+    `,
+	},
+	{
+		version: 8,
+		sql: `
+      -- slack's ids are only unique within a workspace: a channel shared
+      -- between workspaces has the same id in each, as does a user across an
+      -- Enterprise Grid.  So conversations, and their acks and snoozes, and
+      -- users are keyed by the workspace as well as the id.  Until now ids
+      -- were unique, so each ack, snooze and journal entry is in the
+      -- workspace of the conversation with its id.
+      alter table acknowledgements add column team_id text not null default '';
+      update acknowledgements set team_id = coalesce(
+        (select c.team_id from conversations c where c.id = acknowledgements.conversation_id), '');
+      drop index ack_convo_idx;
+      create unique index ack_convo_idx on acknowledgements (
+        team_id, conversation_id, acknowledged_through_ts);
+
+      alter table journal add column team_id text not null default '';
+      update journal set team_id = coalesce(
+        (select c.team_id from conversations c where c.id = journal.conversation_id), '');
+
+      alter table journal_removed_acks add column team_id text not null default '';
+      update journal_removed_acks set team_id = coalesce(
+        (select j.team_id from journal j where j.id = journal_removed_acks.journal_id), '');
+
+      create table snoozes_by_team (
+        team_id text not null default '',
+        conversation_id text not null,
+        snoozed_through_ts text not null,
+        snoozed_until int,
+        snoozed_at int not null,
+        primary key (team_id, conversation_id)
+      );
+      insert into snoozes_by_team
+        (team_id, conversation_id, snoozed_through_ts, snoozed_until, snoozed_at)
+      select
+        coalesce((select c.team_id from conversations c where c.id = s.conversation_id), ''),
+        s.conversation_id, s.snoozed_through_ts, s.snoozed_until, s.snoozed_at
+      from
+        snoozes s;
+      drop table snoozes;
+      alter table snoozes_by_team rename to snoozes;
+
+      create table conversations_by_team (
+        team_id text not null default '',
+        id text not null,
+        conversation_type text not null,
+        display_name text not null,
+        latest_msg_ts text,
+        channel_id text not null default '',
+        thread_ts text not null default '',
+        primary key (team_id, id)
+      );
+      insert into conversations_by_team
+        (team_id, id, conversation_type, display_name, latest_msg_ts, channel_id, thread_ts)
+      select
+        team_id, id, conversation_type, display_name, latest_msg_ts, channel_id, thread_ts
+      from
+        conversations;
+      drop table conversations;
+      alter table conversations_by_team rename to conversations;
+
+      create table users_by_team (
+        team_id text not null default '',
+        id text not null,
+        real_name text not null,
+        display_name text not null,
+        is_bot int not null,
+        deleted int not null,
+        fetched_at int not null,
+        primary key (team_id, id)
+      );
+      insert into users_by_team
+        (team_id, id, real_name, display_name, is_bot, deleted, fetched_at)
+      select
+        team_id, id, real_name, display_name, is_bot, deleted, fetched_at
+      from
+        users;
+      drop table users;
+      alter table users_by_team rename to users;
+    `,
+	},
+}
+
+// Find the version of the db, creating the version table if necessary.  A db
+// without one is brand new, and starts at version 0.
+func getVersion(db *sql.DB) (int, error) {
+	initVersionSql := `
+      create table if not exists version (
+        -- singleton should always be 1, regardless of the version,
+        -- and lets us maintain a single version row
+        singleton int not null primary key,
+        version int not null
+      );
+
+      insert into version (singleton, version)
+      values (1, 0)
+      on conflict(singleton) do nothing;
+    `
+
+	_, err := db.Exec(initVersionSql)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRow("select version from version").Scan(&version)
+	return version, err
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error migrating db to version %d: %s", m.version, err)
+	}
+
+	_, err = tx.Exec("update version set version = ?", m.version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The path a db at dbPath is copied to before migrating it from version.
+func backupPath(dbPath string, version int, now time.Time) string {
+	return fmt.Sprintf("%s.v%d.%s.bak", dbPath, version, now.Format("20060102150405"))
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	// the db describes private conversations, so keep the backup private
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// Bring the db up to SupportedDBVersion, first backing up any existing file
+// db that needs migrating.  Refuses to touch a db newer than this code
+// supports.
+func migrate(db *sql.DB, dbPath string) error {
+	version, err := getVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version > SupportedDBVersion {
+		return &unsupportedVersionError{actualVersion: version, supportedVersion: SupportedDBVersion}
+	}
+
+	if version == SupportedDBVersion {
+		return nil
+	}
+
+	if version > 0 && dbPath != ":memory:" {
+		err = copyFile(dbPath, backupPath(dbPath, version, time.Now()))
+		if err != nil {
+			return fmt.Errorf("Error backing up db before migrating: %s", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		err = applyMigration(db, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Create a file db at the given version from its fixture in testdata.
+func fixtureDB(t *testing.T, version int) string {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create tempdir %s", err)
+	}
+
+	fixture, err := ioutil.ReadFile(filepath.Join("testdata", fmt.Sprintf("v%d.sql", version)))
+	if err != nil {
+		t.Fatalf("Could not read fixture %s", err)
+	}
+
+	dbPath := filepath.Join(dir, "slackbox.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Could not create fixture db %s", err)
+	}
+
+	defer db.Close()
+
+	_, err = db.Exec(string(fixture))
+	if err != nil {
+		t.Fatalf("Could not load fixture %s", err)
+	}
+
+	return dbPath
+}
+
+func checkVersion(t *testing.T, db *SlackBoxDB, expected int) {
+	var version int
+	err := db.db.QueryRow("select version from version").Scan(&version)
+	if err != nil {
+		t.Fatalf("Could not read version %s", err)
+	}
+
+	if version != expected {
+		t.Errorf("Expected version %d, got %d", expected, version)
+	}
+}
+
+func TestMigrationsEndAtSupportedVersion(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.version)
+		}
+	}
+
+	last := migrations[len(migrations)-1]
+	if last.version != SupportedDBVersion {
+		t.Errorf("Last migration is version %d, supported version is %d", last.version, SupportedDBVersion)
+	}
+}
+
+func TestNewDBIsLatestVersion(t *testing.T) {
+	db := memoryDB(t)
+	checkVersion(t, db, SupportedDBVersion)
+}
+
+func TestMigrateFromV1(t *testing.T) {
+	dbPath := fixtureDB(t, 1)
+	defer os.RemoveAll(filepath.Dir(dbPath))
+
+	db, err := ConnectDB(dbPath)
+	if err != nil {
+		t.Fatalf("Could not migrate v1 db %s", err)
+	}
+
+	defer db.db.Close()
+
+	checkVersion(t, db, SupportedDBVersion)
+
+	// the conversations and acks made at v1 survive
+	d1 := Conversation{ID: "D1", ConversationType: "im", DisplayName: "Alice", LatestMsgTs: "2.0"}
+	d2 := Conversation{ID: "D2", ConversationType: "im", DisplayName: "Bob", LatestMsgTs: "3.0"}
+	checkUnacked(t, db, []Conversation{d2})
+
+	foundC := checkGet(t, db, "", d1.ID)
+	if foundC != d1 {
+		t.Errorf("Expected to find conversation %v, found %v", d1, foundC)
+	}
+
+	// and the newer tables are usable
+	err = db.UpdateUsers("T1", []User{{ID: "U1", RealName: "Alice"}}, time.Now())
+	if err != nil {
+		t.Errorf("Could not use users table after migration %s", err)
+	}
+
+	backups, err := filepath.Glob(dbPath + ".v1.*.bak")
+	if err != nil {
+		t.Fatalf("Could not look for backups %s", err)
+	}
+
+	if len(backups) != 1 {
+		t.Fatalf("Expected a single backup of the v1 db, found %v", backups)
+	}
+
+	backup, err := sql.Open("sqlite3", backups[0])
+	if err != nil {
+		t.Fatalf("Could not open backup %s", err)
+	}
+
+	defer backup.Close()
+
+	var version int
+	err = backup.QueryRow("select version from version").Scan(&version)
+	if err != nil || version != 1 {
+		t.Errorf("Expected backup to be at version 1, got %d (%v)", version, err)
+	}
+}
+
+func TestMigratingIsIdempotent(t *testing.T) {
+	dbPath := fixtureDB(t, 1)
+	defer os.RemoveAll(filepath.Dir(dbPath))
+
+	for i := 0; i < 2; i++ {
+		db, err := ConnectDB(dbPath)
+		if err != nil {
+			t.Fatalf("Could not connect to db %s", err)
+		}
+		checkVersion(t, db, SupportedDBVersion)
+		db.db.Close()
+	}
+
+	// only the first connection had anything to migrate
+	backups, err := filepath.Glob(dbPath + ".*.bak")
+	if err != nil {
+		t.Fatalf("Could not look for backups %s", err)
+	}
+
+	if len(backups) != 1 {
+		t.Errorf("Expected a single backup, found %v", backups)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+)
+
+// Matches, in order of precedence: code blocks, inline code, <...> entities
+// like mentions and links, then *bold*, _italic_ and ~strike~.
+var mrkdwnPattern = regexp.MustCompile("```([\\s\\S]*?)```|`([^`\\n]+)`|<([^>\\n]+)>|\\*([^*\\n]+)\\*|_([^_\\n]+)_|~([^~\\n]+)~")
+
+const (
+	codeBlockGroup = iota + 1
+	codeGroup
+	entityGroup
+	boldGroup
+	italicGroup
+	strikeGroup
+)
+
+// Slack escapes these three, and only these three, in message text.
+var slackUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+func plainText(text string) string {
+	return tview.Escape(slackUnescaper.Replace(text))
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Slack only treats *, _ and ~ as formatting at word boundaries, so that e.g.
+// snake_case_names come through intact.
+func atWordBoundaries(text string, start int, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	return !(start > 0 && isWordRune(before)) && !(end < len(text) && isWordRune(after))
+}
+
+// The tag that shows text with the attributes given, e.g. [::bu], or with
+// none.  tview's attribute tags replace the attributes in effect rather than
+// adding to them, so closing a span has to restore those around it.
+func attributesTag(attributes string) string {
+	if attributes == "" {
+		return "[::-]"
+	}
+	return "[::" + attributes + "]"
+}
+
+// The attributes with one more turned on.
+func withAttribute(attributes string, attribute string) string {
+	if strings.Contains(attributes, attribute) {
+		return attributes
+	}
+	return attributes + attribute
+}
+
+// Render a slack <...> entity, e.g. <@U123>, <#C123|general> or
+// <https://example.com|a link>, within text shown with the attributes given.
+func renderEntity(entity string, userName func(string) string, attributes string) string {
+	target := entity
+	label := ""
+	if i := strings.Index(entity, "|"); i >= 0 {
+		target = entity[:i]
+		label = entity[i+1:]
+	}
+
+	bold := attributesTag(withAttribute(attributes, "b"))
+	end := attributesTag(attributes)
+
+	switch {
+	case strings.HasPrefix(target, "@"):
+		if label == "" {
+			label = userName(target[1:])
+		}
+		return bold + "@" + plainText(strings.TrimPrefix(label, "@")) + end
+	case strings.HasPrefix(target, "#"):
+		if label == "" {
+			label = target[1:]
+		}
+		return bold + "#" + plainText(label) + end
+	case strings.HasPrefix(target, "!"):
+		// special mentions like <!here>, or <!subteam^ID|@team>
+		if label == "" {
+			label = target[1:]
+		}
+		return bold + "@" + plainText(strings.TrimPrefix(label, "@")) + end
+	default:
+		if label == "" {
+			label = strings.TrimPrefix(target, "mailto:")
+		}
+		return attributesTag(withAttribute(attributes, "u")) + plainText(label) + end
+	}
+}
+
+// Render slack's mrkdwn message format as tview color tags.  userName names
+// the user with the given id, for mentions.
+//
+// tcell can't show italics or strikethrough, so _italic_ is underlined and
+// ~strike~ is dimmed instead.
+func renderMrkdwn(text string, userName func(string) string) string {
+	return renderMrkdwnWithin(text, userName, "")
+}
+
+// Render mrkdwn nested in a span shown with the attributes given, which are
+// kept on for the rest of the text once an inner span ends.
+func renderMrkdwnWithin(text string, userName func(string) string, attributes string) string {
+	var rendered strings.Builder
+
+	// renders the text of a span of emphasis, with one more attribute on
+	emphasize := func(inner string, attribute string) string {
+		within := withAttribute(attributes, attribute)
+		return attributesTag(within) + renderMrkdwnWithin(inner, userName, within) + attributesTag(attributes)
+	}
+
+	pos := 0
+	for pos < len(text) {
+		match := mrkdwnPattern.FindStringSubmatchIndex(text[pos:])
+		if match == nil {
+			break
+		}
+
+		start, end := pos+match[0], pos+match[1]
+		group := func(n int) string {
+			return text[pos+match[2*n] : pos+match[2*n+1]]
+		}
+		matched := func(n int) bool {
+			return match[2*n] >= 0
+		}
+
+		emphasis := matched(boldGroup) || matched(italicGroup) || matched(strikeGroup)
+		if emphasis && !atWordBoundaries(text, start, end) {
+			// not formatting after all, so move past the marker and look again
+			rendered.WriteString(plainText(text[pos : start+1]))
+			pos = start + 1
+			continue
+		}
+
+		rendered.WriteString(plainText(text[pos:start]))
+
+		switch {
+		case matched(codeBlockGroup):
+			rendered.WriteString("[yellow]" + plainText(strings.Trim(group(codeBlockGroup), "\n")) + "[-]")
+		case matched(codeGroup):
+			rendered.WriteString("[yellow]" + plainText(group(codeGroup)) + "[-]")
+		case matched(entityGroup):
+			rendered.WriteString(renderEntity(group(entityGroup), userName, attributes))
+		case matched(boldGroup):
+			rendered.WriteString(emphasize(group(boldGroup), "b"))
+		case matched(italicGroup):
+			rendered.WriteString(emphasize(group(italicGroup), "u"))
+		case matched(strikeGroup):
+			rendered.WriteString(emphasize(group(strikeGroup), "d"))
+		}
+
+		pos = end
+	}
+
+	rendered.WriteString(plainText(text[pos:]))
+
+	return rendered.String()
+}
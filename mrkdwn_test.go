@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func testUserName(id string) string {
+	if id == "U1" {
+		return "alice"
+	}
+	return id
+}
+
+func TestRenderMrkdwn(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"plain text", "plain text"},
+		{"*bold* and _italic_ and ~strike~", "[::b]bold[::-] and [::u]italic[::-] and [::d]strike[::-]"},
+		{"some `code` here", "some [yellow]code[-] here"},
+		{"```\nfunc *main*()\n```", "[yellow]func *main*()[-]"},
+		{"snake_case_name and 2*3*4", "snake_case_name and 2*3*4"},
+		{"hi <@U1>, see <#C1|general>", "hi [::b]@alice[::-], see [::b]#general[::-]"},
+		{"<!here> <!subteam^S1|@team>", "[::b]@here[::-] [::b]@team[::-]"},
+		{"<https://example.com|a link> <https://example.com>", "[::u]a link[::-] [::u]https://example.com[::-]"},
+		{"*bold <@U1> more*", "[::b]bold [::b]@alice[::b] more[::-]"},
+		{"_italic *both* <https://example.com|link> again_ after", "[::u]italic [::ub]both[::u] [::u]link[::u] again[::-] after"},
+		{"a &lt;b&gt; &amp; c", "a <b> & c"},
+		{"[red] isn't a tag", "[red[] isn't a tag"},
+	}
+
+	for _, c := range cases {
+		actual := renderMrkdwn(c.text, testUserName)
+		if actual != c.expected {
+			t.Errorf("Rendering %q expected %q, got %q", c.text, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// The most unread messages shown in the preview of a conversation.
+const previewLimit = 50
+
+// Previews are only good until there's a new message or ack, so key them on
+// both.
+func previewKey(ac AcknowledgedConversation) string {
+	return fmt.Sprintf("%s/%s/%s/%s", ac.TeamID, ac.ID, ac.AcknowledgedThroughTs, ac.LatestMsgTs)
+}
+
+// A conversation's preview, good for as long as its previewKey stays the
+// same.  Only the latest is kept for each conversation, so the cache never
+// holds more than one preview per conversation however long slackbox runs.
+type renderedPreview struct {
+	key  string
+	text string
+}
+
+func slackTsToTime(ts string) time.Time {
+	secs, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(secs), 0)
+}
+
+// The slack ts of the given time, for comparing against message timestamps.
+func timeToSlackTs(t time.Time) string {
+	return fmt.Sprintf("%d.000000", t.Unix())
+}
+
+func (ib *inbox) renderPreview(api SlackAPI, messages []Message) string {
+	if len(messages) == 0 {
+		return fmt.Sprintf("[%s]No unread messages[-]", tagColor(ib.display.theme.Muted))
+	}
+
+	var rendered strings.Builder
+	for _, msg := range messages {
+		sent := slackTsToTime(msg.Ts).Format("Jan 2 15:04")
+		fmt.Fprintf(&rendered, "[::b]%s[::-] [%s]%s[-]\n", tview.Escape(msg.Author), tagColor(ib.display.theme.Muted), sent)
+		fmt.Fprintf(&rendered, "%s\n\n", renderMrkdwn(msg.Text, api.UserName))
+	}
+
+	return rendered.String()
+}
+
+// A mention is previewed as just the mentioning message, anything else as
+// its unread messages.
+func (ib *inbox) fetchPreviewMessages(api SlackAPI, ac AcknowledgedConversation) ([]Message, error) {
+	if ac.ConversationType != "mention" {
+		return api.FetchMessages(ib.ctx, ac.SlackChannelID(), ac.ThreadTs, ac.AcknowledgedThroughTs, previewLimit)
+	}
+
+	msg, err := api.FetchMessage(ib.ctx, ac.SlackChannelID(), ac.ThreadTs, ac.LatestMsgTs)
+	if err != nil {
+		return nil, err
+	}
+	return []Message{msg}, nil
+}
+
+func (ib *inbox) selectedConversation() (AcknowledgedConversation, bool) {
+	i := ib.list.GetCurrentItem()
+	if i < 0 || i >= len(ib.unacked) || ib.unacked[i].ID == "" {
+		return AcknowledgedConversation{}, false
+	}
+	return ib.unacked[i], true
+}
+
+// Show the unread messages of the selected conversation, fetching them in the
+// background the first time it's selected.
+func (ib *inbox) showPreview() {
+	ac, ok := ib.selectedConversation()
+	if !ok {
+		ib.preview.Clear()
+		ib.preview.SetTitle("")
+		return
+	}
+
+	ib.preview.SetTitle(ac.DisplayName)
+
+	key := previewKey(ac)
+	if rendered, ok := ib.previews[ac.key()]; ok && rendered.key == key {
+		ib.preview.SetText(rendered.text)
+		ib.preview.ScrollToEnd()
+		return
+	}
+
+	ib.preview.SetText(fmt.Sprintf("[%s]Loading...[-]", tagColor(ib.display.theme.Muted)))
+
+	if ib.loadingPreviews[key] {
+		return
+	}
+	api, err := ib.apiFor(ac.Conversation)
+	if err != nil {
+		ib.preview.SetText(fmt.Sprintf("[%s]%s[-]", tagColor(ib.display.theme.Error), tview.Escape(err.Error())))
+		return
+	}
+
+	ib.loadingPreviews[key] = true
+	go func() {
+		messages, err := ib.fetchPreviewMessages(api, ac)
+		ib.app.QueueUpdateDraw(func() {
+			delete(ib.loadingPreviews, key)
+			if err != nil {
+				// not cached, so selecting the conversation again retries
+				if current, ok := ib.selectedConversation(); ok && previewKey(current) == key {
+					ib.preview.SetText(fmt.Sprintf("[%s]%s[-]", tagColor(ib.display.theme.Error), tview.Escape(err.Error())))
+				}
+				return
+			}
+
+			// replacing any preview from before a new message or ack
+			ib.previews[ac.key()] = renderedPreview{key: key, text: ib.renderPreview(api, messages)}
+			if current, ok := ib.selectedConversation(); ok && previewKey(current) == key {
+				ib.showPreview()
+			}
+		})
+	}()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Requests per minute allowed for each of slack's rate limit tiers, see
+// https://api.slack.com/docs/rate-limits
+const (
+	tier2 = 20
+	tier3 = 50
+	tier4 = 100
+	// chat.postMessage is limited to about one message per second
+	postMessageTier = 60
+)
+
+var methodTiers = map[string]int{
+	"conversations.history": tier3,
+	"conversations.info":    tier3,
+	"conversations.members": tier4,
+	"conversations.replies": tier3,
+	"users.info":            tier4,
+	"users.list":            tier2,
+	"users.conversations":   tier3,
+	"search.messages":       tier2,
+	"chat.getPermalink":     tier4,
+	"chat.postMessage":      postMessageTier,
+}
+
+const (
+	// Methods we don't know the tier of are assumed to be in the strictest
+	// one we use.
+	defaultTier = tier2
+	// How many times to retry a call that failed with a transient error
+	// before giving up on it.  Rate limited calls are always retried.
+	maxTransientRetries = 5
+	baseBackoff         = 500 * time.Millisecond
+	maxBackoff          = 30 * time.Second
+)
+
+// A token bucket for a single method, holding up to a minute's budget of
+// calls and refilling continuously.
+type bucket struct {
+	tokens   float64
+	capacity float64
+	last     time.Time
+}
+
+// Wraps calls to slack, pacing each method to stay within its tier budget and
+// retrying calls that were rate limited or hit a transient network error.
+type rateLimiter struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	now      func() time.Time
+	sleep    func(context.Context, time.Duration) error
+	progress func(string)
+}
+
+func newRateLimiter(progress func(string)) *rateLimiter {
+	if progress == nil {
+		progress = func(string) {}
+	}
+
+	return &rateLimiter{
+		buckets:  make(map[string]*bucket),
+		now:      time.Now,
+		sleep:    sleepContext,
+		progress: progress,
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Take a token for method, returning how long to wait before using it.
+func (rl *rateLimiter) reserve(method string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+
+	b, ok := rl.buckets[method]
+	if !ok {
+		perMinute, ok := methodTiers[method]
+		if !ok {
+			perMinute = defaultTier
+		}
+		b = &bucket{tokens: float64(perMinute), capacity: float64(perMinute), last: now}
+		rl.buckets[method] = b
+	}
+
+	b.tokens += now.Sub(b.last).Minutes() * b.capacity
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	// the bucket is in debt, so wait until it's paid back
+	return time.Duration(-b.tokens / b.capacity * float64(time.Minute))
+}
+
+// Slack told us to back off, so empty the method's bucket to keep the other
+// workers from piling on.
+func (rl *rateLimiter) drain(method string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if b, ok := rl.buckets[method]; ok && b.tokens > 0 {
+		b.tokens = 0
+	}
+}
+
+// Whether retrying the call might work: slack's servers erroring, or the
+// network timing out or dropping the connection.  Every transport failure is
+// a net.Error, but those like a host that doesn't resolve, a bad certificate
+// or a refused connection won't fix themselves, and giving up is never worth
+// retrying.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// net only counts resets as temporary while accepting connections, but
+	// slack dropping one we'd kept alive is the commonest blip of all
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		return statusErr.HTTPStatusCode() >= 500
+	}
+
+	return false
+}
+
+// Full jitter exponential backoff, see
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func backoff(attempt int) time.Duration {
+	ceiling := baseBackoff << uint(attempt)
+	if ceiling > maxBackoff || ceiling <= 0 {
+		ceiling = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// Call fn, which should make a single call to the slack method, until it
+// succeeds, fails with an error that retrying won't fix, or ctx is done.
+func (rl *rateLimiter) call(ctx context.Context, method string, fn func() error) error {
+	return rl.retry(ctx, method, maxTransientRetries, fn)
+}
+
+// Like call, but for methods it isn't safe to repeat, like posting a
+// message: a timeout or server error may come after slack did what was
+// asked, so only being rate limited, which means it wasn't, is retried.
+func (rl *rateLimiter) callOnce(ctx context.Context, method string, fn func() error) error {
+	return rl.retry(ctx, method, 0, fn)
+}
+
+func (rl *rateLimiter) retry(ctx context.Context, method string, maxRetries int, fn func() error) error {
+	transientRetries := 0
+
+	for {
+		wait := rl.reserve(method)
+		if wait > 0 {
+			err := rl.sleep(ctx, wait)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := fn()
+
+		var rateLimited *slack.RateLimitedError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &rateLimited):
+			rl.drain(method)
+			rl.progress(fmt.Sprintf("Rate limited by slack on %s, retrying in %s", method, rateLimited.RetryAfter))
+			wait = rateLimited.RetryAfter
+		case isTransient(err) && transientRetries < maxRetries && ctx.Err() == nil:
+			wait = backoff(transientRetries)
+			transientRetries++
+			rl.progress(fmt.Sprintf("Error calling %s (%s), retrying in %s", method, err, wait.Round(time.Millisecond)))
+		default:
+			return err
+		}
+
+		err = rl.sleep(ctx, wait)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// A rate limiter on a fake clock, where sleeping just moves the clock along
+// and records how long was slept.
+func fakeRateLimiter() (*rateLimiter, *[]time.Duration) {
+	now := time.Unix(0, 0)
+	slept := make([]time.Duration, 0)
+
+	rl := newRateLimiter(nil)
+	rl.now = func() time.Time {
+		return now
+	}
+	rl.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return ctx.Err()
+	}
+
+	return rl, &slept
+}
+
+// Returns a func that fails with each of errs in turn, then succeeds.
+func failingCall(errs ...error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestRateLimiterHonorsRetryAfter(t *testing.T) {
+	rl, slept := fakeRateLimiter()
+
+	progress := make([]string, 0)
+	rl.progress = func(msg string) {
+		progress = append(progress, msg)
+	}
+
+	fn, calls := failingCall(&slack.RateLimitedError{RetryAfter: 30 * time.Second})
+	err := rl.call(context.Background(), "users.info", fn)
+	if err != nil {
+		t.Fatalf("Expected rate limited call to succeed on retry, got %s", err)
+	}
+
+	if *calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", *calls)
+	}
+
+	if len(*slept) == 0 || (*slept)[0] != 30*time.Second {
+		t.Errorf("Expected to wait 30s first, waited %v", *slept)
+	}
+
+	if len(progress) != 1 {
+		t.Errorf("Expected the rate limiting to be reported once, got %v", progress)
+	}
+}
+
+func TestRateLimiterRetriesTransientErrors(t *testing.T) {
+	rl, _ := fakeRateLimiter()
+
+	fn, calls := failingCall(timeoutError{}, timeoutError{})
+	err := rl.call(context.Background(), "users.info", fn)
+	if err != nil {
+		t.Fatalf("Expected transient errors to be retried, got %s", err)
+	}
+
+	if *calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", *calls)
+	}
+}
+
+func TestRateLimiterGivesUpOnTransientErrors(t *testing.T) {
+	rl, _ := fakeRateLimiter()
+
+	errs := make([]error, maxTransientRetries+1)
+	for i := range errs {
+		errs[i] = timeoutError{}
+	}
+
+	fn, calls := failingCall(errs...)
+	err := rl.call(context.Background(), "users.info", fn)
+	if err == nil {
+		t.Fatal("Expected to give up after repeated transient errors")
+	}
+
+	if *calls != maxTransientRetries+1 {
+		t.Errorf("Expected %d calls, got %d", maxTransientRetries+1, *calls)
+	}
+}
+
+func TestRateLimiterCallOnceOnlyRetriesRateLimits(t *testing.T) {
+	rl, slept := fakeRateLimiter()
+
+	// slack may have posted before timing out, so posting again could
+	// double it
+	fn, calls := failingCall(timeoutError{})
+	err := rl.callOnce(context.Background(), "chat.postMessage", fn)
+	if err != (timeoutError{}) {
+		t.Errorf("Expected the timeout, got %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("Expected 1 call, got %d", *calls)
+	}
+
+	// but being rate limited means it didn't
+	fn, calls = failingCall(&slack.RateLimitedError{RetryAfter: 2 * time.Second})
+	err = rl.callOnce(context.Background(), "chat.postMessage", fn)
+	if err != nil {
+		t.Fatalf("Expected rate limited call to succeed on retry, got %s", err)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", *calls)
+	}
+	if len(*slept) == 0 || (*slept)[len(*slept)-1] != 2*time.Second {
+		t.Errorf("Expected to wait 2s, waited %v", *slept)
+	}
+}
+
+func TestRateLimiterDoesNotRetryOtherErrors(t *testing.T) {
+	rl, _ := fakeRateLimiter()
+
+	notFound := errors.New("user_not_found")
+	fn, calls := failingCall(notFound)
+	err := rl.call(context.Background(), "users.info", fn)
+	if err != notFound {
+		t.Errorf("Expected %s, got %s", notFound, err)
+	}
+
+	if *calls != 1 {
+		t.Errorf("Expected 1 call, got %d", *calls)
+	}
+}
+
+func TestRateLimiterPacesMethodsWithinTier(t *testing.T) {
+	rl, slept := fakeRateLimiter()
+
+	for i := 0; i < tier2+1; i++ {
+		err := rl.call(context.Background(), "users.list", func() error { return nil })
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err)
+		}
+	}
+
+	// a full minute's budget goes straight through, then we have to wait
+	// for the bucket to refill
+	if len(*slept) != 1 {
+		t.Fatalf("Expected a single wait, got %v", *slept)
+	}
+
+	expected := time.Minute / tier2
+	if (*slept)[0] != expected {
+		t.Errorf("Expected to wait %s, waited %s", expected, (*slept)[0])
+	}
+
+	// other methods have their own budgets
+	err := rl.call(context.Background(), "users.info", func() error { return nil })
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if len(*slept) != 1 {
+		t.Errorf("Expected no wait for a different method, got %v", *slept)
+	}
+}
+
+func TestRateLimiterStopsWhenCancelled(t *testing.T) {
+	rl, _ := fakeRateLimiter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fn, calls := failingCall(&slack.RateLimitedError{RetryAfter: time.Second})
+	err := rl.call(ctx, "users.info", fn)
+	if err != context.Canceled {
+		t.Errorf("Expected cancellation, got %s", err)
+	}
+
+	if *calls != 1 {
+		t.Errorf("Expected 1 call, got %d", *calls)
+	}
+}
+
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("slack server error: %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
+
+func TestIsTransient(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://slack.com/api/users.info", Err: err}
+	}
+
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"timeout", timeoutError{}, true},
+		{"timeout in a url error", urlError(timeoutError{}), true},
+		{"connection reset", urlError(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), true},
+		{"server error", statusError(503), true},
+		{"client error", statusError(404), false},
+		{"slack error", errors.New("user_not_found"), false},
+		{"host not found", urlError(&net.DNSError{Err: "no such host", Name: "slack.invalid", IsNotFound: true}), false},
+		{"connection refused", urlError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), false},
+		{"bad certificate", urlError(x509.UnknownAuthorityError{}), false},
+		{"cancelled", urlError(context.Canceled), false},
+		{"deadline", urlError(context.DeadlineExceeded), false},
+	}
+
+	for _, c := range cases {
+		if transient := isTransient(c.err); transient != c.expected {
+			t.Errorf("Expected %s (%s) transient to be %v, got %v", c.name, c.err, c.expected, transient)
+		}
+	}
+}
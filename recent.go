@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rivo/tview"
+)
+
+// How many of the most recently read conversations the recent view shows.
+const recentLimit = 50
+
+func describeAck(rc RecentConversation) string {
+	if rc.AcknowledgedAt.IsZero() {
+		return "read"
+	}
+	return "read " + rc.AcknowledgedAt.Format("Mon Jan 2 15:04")
+}
+
+// Show the conversations read most recently in place of the inbox, marking
+// those with new messages since.  The unack key marks the selected one unread
+// again, and Enter opens it in slack.
+func (ib *inbox) showRecent() {
+	var recent []RecentConversation
+
+	ib.showSubview(
+		fmt.Sprintf("Recently read (%s marks unread, Enter opens in slack, Esc returns)", ib.display.keys.names(unackKey)),
+		func(list *tview.List) error {
+			var err error
+			recent, err = ib.db.GetRecentlyAckedConversations(recentLimit)
+			if err != nil {
+				return err
+			}
+
+			for _, rc := range recent {
+				format := "  %s [%s]%s[-]"
+				if rc.LatestMsgTs > rc.AcknowledgedThroughTs {
+					format = "[::b]* %s[::-] [%s]%s[-]"
+				}
+				list.AddItem(fmt.Sprintf(format, tview.Escape(rc.DisplayName), tagColor(ib.display.theme.Muted), describeAck(rc)), "", 0, ib.createSelectFunc(rc.AcknowledgedConversation))
+			}
+			return nil
+		},
+		func(action string, i int) error {
+			if action != unackKey {
+				return nil
+			}
+			_, err := ib.db.UnackLatest(recent[i].Conversation, noPosition)
+			return err
+		})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// What a feature of slackbox needs its token to be granted.
+type scopeRequirement struct {
+	feature string
+	scopes  []string
+	// how to run without the feature, or blank if slackbox can't
+	without string
+	// whether slackbox still starts without the scopes
+	optional bool
+	// whether slackbox turns the feature off without the scopes, rather
+	// than letting it fail
+	skipped bool
+	// what the feature needs, when that's more than scopes an app can be
+	// given
+	needs string
+}
+
+const (
+	mentionsFeature = "Searching for mentions"
+	realtimeFeature = "Updating in real time"
+)
+
+var (
+	dmScopes      = []string{"im:read", "im:history", "mpim:read", "mpim:history"}
+	channelScopes = []string{"channels:read", "channels:history", "groups:read", "groups:history"}
+)
+
+// The scopes needed by the features turned on.
+func scopeRequirements(opts APIOptions) []scopeRequirement {
+	reqs := []scopeRequirement{
+		{feature: "Reading direct messages", scopes: dmScopes},
+		{feature: "Showing who wrote messages", scopes: []string{"users:read"}},
+		{feature: "Showing the workspace's name", scopes: []string{"team:read"}},
+	}
+
+	if !opts.Channels.empty() {
+		reqs = append(reqs, scopeRequirement{feature: "Tracking channels", scopes: channelScopes, without: "track no channels, by leaving out -channels"})
+	}
+	if opts.Mentions {
+		reqs = append(reqs, scopeRequirement{feature: mentionsFeature, scopes: []string{"search:read"}, optional: true, skipped: true})
+	}
+	// slack only lets tokens with the client scope of its older apps use
+	// RTM; apps made now, like the one slackbox login uses, can't be given it
+	if opts.Realtime {
+		reqs = append(reqs, scopeRequirement{
+			feature:  realtimeFeature,
+			scopes:   []string{"client"},
+			optional: true,
+			skipped:  true,
+			needs:    "a token that can use slack's RTM api, which only classic slack apps' tokens with the client scope can",
+		})
+	}
+
+	return append(reqs, scopeRequirement{feature: "Replying", scopes: []string{"chat:write"}, optional: true})
+}
+
+// Every scope any feature needs, which is what logging in asks for.
+func allScopes() []string {
+	all := make([]string, 0)
+	for _, req := range scopeRequirements(APIOptions{Channels: NewChannelFilter("all", ""), Mentions: true}) {
+		all = append(all, req.scopes...)
+	}
+	return all
+}
+
+// The scopes of classic slack apps that cover granular ones.
+var classicScopes = map[string][]string{
+	"read": append(append([]string{"users:read", "team:read", "search:read"}, dmScopes...), channelScopes...),
+	"post": {"chat:write"},
+}
+
+// The requirements the granted scopes fall short of, each with only the
+// scopes it's missing.
+func missingScopes(granted []string, reqs []scopeRequirement) []scopeRequirement {
+	has := make(map[string]bool)
+	for _, scope := range granted {
+		has[scope] = true
+		for _, covered := range classicScopes[scope] {
+			has[covered] = true
+		}
+	}
+	// the scope of the official clients, which can do anything
+	if has["client"] {
+		return nil
+	}
+
+	missing := make([]scopeRequirement, 0)
+	for _, req := range reqs {
+		lacking := make([]string, 0)
+		for _, scope := range req.scopes {
+			if !has[scope] {
+				lacking = append(lacking, scope)
+			}
+		}
+
+		if len(lacking) > 0 {
+			req.scopes = lacking
+			missing = append(missing, req)
+		}
+	}
+	return missing
+}
+
+// Whether slackbox can start without the scopes missing.
+func canStartWithout(missing []scopeRequirement) bool {
+	for _, req := range missing {
+		if !req.optional {
+			return false
+		}
+	}
+	return true
+}
+
+// Explain what the token for the workspace can't do and how to fix it.
+func scopeReport(teamName string, missing []scopeRequirement) string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "The token for %s is missing scopes slackbox needs:\n", teamName)
+
+	addable := false
+	for _, req := range missing {
+		needs := req.needs
+		if needs == "" {
+			needs = strings.Join(req.scopes, ", ")
+			addable = true
+		}
+		fmt.Fprintf(&report, "  %s needs %s", req.feature, needs)
+		switch {
+		case req.without != "":
+			fmt.Fprintf(&report, " (or %s)", req.without)
+		case req.skipped:
+			fmt.Fprintf(&report, " (slackbox runs without it, but skips %s)", strings.ToLower(req.feature))
+		case req.optional:
+			fmt.Fprintf(&report, " (slackbox runs without it, but %s will fail)", strings.ToLower(req.feature))
+		}
+		fmt.Fprintln(&report)
+	}
+
+	if addable {
+		fmt.Fprint(&report, "Add them to the user token scopes of your slack app, reinstall it to the workspace, and get a new token, e.g. with slackbox login.")
+	}
+	return strings.TrimSuffix(report.String(), "\n")
+}
+
+// Who the token belongs to, from auth.test, and the scopes granted to it,
+// from the X-OAuth-Scopes header slack answers every call with, or nil if it
+// doesn't say, as for some legacy tokens.  slack-go doesn't give access to
+// headers, hence calling auth.test by hand.
+func authTest(apiURL string, token string) (*slack.AuthTestResponse, []string, error) {
+	req, err := http.NewRequest("POST", apiURL+"auth.test", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error checking the token: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Error checking the token: slack answered %s", resp.Status)
+	}
+
+	var body struct {
+		slack.SlackResponse
+		slack.AuthTestResponse
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("Error checking the token: %s", err)
+	}
+	if !body.Ok {
+		return nil, nil, fmt.Errorf("Error checking the token: %s", body.Error)
+	}
+
+	if _, found := resp.Header[http.CanonicalHeaderKey("X-OAuth-Scopes")]; !found {
+		return &body.AuthTestResponse, nil, nil
+	}
+
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return &body.AuthTestResponse, scopes, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScopeRequirements(t *testing.T) {
+	features := func(reqs []scopeRequirement) []string {
+		names := make([]string, 0, len(reqs))
+		for _, req := range reqs {
+			names = append(names, req.feature)
+		}
+		return names
+	}
+
+	expected := []string{"Reading direct messages", "Showing who wrote messages", "Showing the workspace's name", "Replying"}
+	if got := features(scopeRequirements(APIOptions{})); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected requirements %v with nothing turned on, got %v", expected, got)
+	}
+
+	expected = []string{"Reading direct messages", "Showing who wrote messages", "Showing the workspace's name", "Tracking channels", "Searching for mentions", "Updating in real time", "Replying"}
+	opts := APIOptions{Channels: NewChannelFilter("#general", ""), Mentions: true, Realtime: true}
+	if got := features(scopeRequirements(opts)); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected requirements %v with everything turned on, got %v", expected, got)
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	reqs := scopeRequirements(APIOptions{Channels: NewChannelFilter("all", ""), Mentions: true})
+
+	if missing := missingScopes(allScopes(), reqs); len(missing) != 0 {
+		t.Errorf("Expected nothing missing with every scope, got %v", missing)
+	}
+
+	// classic apps' scopes cover the granular ones
+	if missing := missingScopes([]string{"identify", "read", "post"}, reqs); len(missing) != 0 {
+		t.Errorf("Expected nothing missing with classic scopes, got %v", missing)
+	}
+	if missing := missingScopes([]string{"client"}, reqs); len(missing) != 0 {
+		t.Errorf("Expected nothing missing with the client scope, got %v", missing)
+	}
+
+	missing := missingScopes([]string{"im:read", "im:history", "mpim:read", "mpim:history", "users:read", "team:read", "channels:read", "chat:write"}, reqs)
+	expected := []scopeRequirement{
+		{feature: "Tracking channels", scopes: []string{"channels:history", "groups:read", "groups:history"}, without: "track no channels, by leaving out -channels"},
+		{feature: "Searching for mentions", scopes: []string{"search:read"}, optional: true, skipped: true},
+	}
+	if !reflect.DeepEqual(expected, missing) {
+		t.Errorf("Expected missing %v, got %v", expected, missing)
+	}
+	if canStartWithout(missing) {
+		t.Errorf("Expected slackbox not to start without channel history")
+	}
+
+	missing = missingScopes([]string{"im:read", "im:history", "mpim:read", "mpim:history", "users:read", "team:read"}, scopeRequirements(APIOptions{}))
+	if !canStartWithout(missing) {
+		t.Errorf("Expected slackbox to start without only chat:write, got %v", missing)
+	}
+}
+
+func TestScopeReport(t *testing.T) {
+	missing := []scopeRequirement{
+		{feature: "Searching for mentions", scopes: []string{"search:read"}, optional: true, skipped: true},
+		{feature: "Replying", scopes: []string{"chat:write"}, optional: true},
+	}
+
+	report := scopeReport("Acme", missing)
+	for _, expected := range []string{
+		"The token for Acme is missing scopes",
+		"Searching for mentions needs search:read (slackbox runs without it, but skips searching for mentions)",
+		"Replying needs chat:write (slackbox runs without it, but replying will fail)",
+		"slackbox login",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected the report to contain %q, got\n%s", expected, report)
+		}
+	}
+}
+
+// Tokens from apps made now can't be given what RTM needs, so there's no
+// scope to tell the user to add.
+func TestScopeReportRealtime(t *testing.T) {
+	reqs := scopeRequirements(APIOptions{Realtime: true})
+	missing := missingScopes(allScopes(), reqs)
+	if len(missing) != 1 || missing[0].feature != realtimeFeature || !canStartWithout(missing) {
+		t.Fatalf("Expected slackbox to start without only realtime, got %v", missing)
+	}
+	if missing := missingScopes([]string{"client"}, reqs); len(missing) != 0 {
+		t.Errorf("Expected nothing missing with the client scope, got %v", missing)
+	}
+
+	report := scopeReport("Acme", missing)
+	if !strings.Contains(report, "Updating in real time needs a token that can use slack's RTM api") ||
+		!strings.Contains(report, "skips updating in real time") {
+		t.Errorf("Expected the report to say realtime needs a token that can use RTM, got\n%s", report)
+	}
+	if strings.Contains(report, "Add them") {
+		t.Errorf("Expected no scopes to add for realtime, got\n%s", report)
+	}
+}
@@ -1,12 +1,131 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/slack-go/slack"
 )
 
 type SlackBoxAPI struct {
-	client   *slack.Client
+	client *slack.Client
+	// for the few calls slack-go doesn't decode all of
+	apiURL   string
+	token    string
+	teamID   string
 	teamName string
+	userID   string
+	channels ChannelFilter
+	threads  bool
+	// how far back to look for threads to follow
+	threadLookback time.Duration
+	mentions       bool
+	realtime       bool
+	workers        int
+	limiter        *rateLimiter
+	// what the token's scopes fall short of, though not by enough to stop
+	// slackbox starting
+	missingScopes []scopeRequirement
+
+	// names of users, from the directory or looked up one by one
+	usersLock sync.RWMutex
+	users     map[string]User
+
+	// channels FetchConversation found we don't track, so messages in busy
+	// ones don't each cost a conversations.info.  Forgotten on refresh, in
+	// case we've joined them or the filter would now take them.
+	untrackedLock sync.Mutex
+	untracked     map[string]bool
+}
+
+// The slack operations the rest of slackbox needs, implemented by
+// SlackBoxAPI, so they can be faked in tests.
+type SlackAPI interface {
+	TeamID() string
+	TeamName() string
+	// Report rate limiting, retries and fetch progress to progress.
+	OnProgress(progress func(string))
+	FollowsThreads() bool
+	FetchUsers(ctx context.Context) ([]User, error)
+	SetUsers(users map[string]User)
+	UserName(id string) string
+	FetchConversations(ctx context.Context, latestMsgTimestamps map[string]string) ([]Conversation, error)
+	FetchThread(ctx context.Context, thread Conversation, parentName string) (Conversation, error)
+	FetchThreads(ctx context.Context, threads []Conversation) ([]Conversation, error)
+	FetchMentions(ctx context.Context, since string, tracked map[string]string) ([]Conversation, error)
+	FetchConversationLink(id string, ts string) (string, error)
+	FetchMessages(ctx context.Context, conversationID string, threadTs string, oldest string, limit int) ([]Message, error)
+	FetchMessage(ctx context.Context, channelID string, threadTs string, ts string) (Message, error)
+	PostMessage(ctx context.Context, conversationID string, text string, threadTs string) (string, error)
+}
+
+type APIOptions struct {
+	// Which channels to track alongside IMs and MPIMs
+	Channels ChannelFilter
+	// Whether to follow threads as conversations of their own
+	Threads bool
+	// How long ago a message can have been posted and still have threads
+	// off it found to follow
+	ThreadLookback time.Duration
+	// Whether to search for mentions outside the conversations we track
+	Mentions bool
+	// Whether the inbox will follow slack's RTM event stream
+	Realtime bool
+	// How many conversations to fetch from slack at once
+	Workers int
+	// Where slack's web api is, blank for slack.com's
+	APIURL string
+}
+
+// Decides which public and private channels are tracked alongside IMs.  By
+// default no channels are tracked; channels can be opted in by name or ID, or
+// all member channels can be opted in with "all" and then some opted back out.
+type ChannelFilter struct {
+	all      bool
+	included map[string]bool
+	excluded map[string]bool
+}
+
+func splitChannelList(list string) map[string]bool {
+	channels := make(map[string]bool)
+	for _, channel := range strings.Split(list, ",") {
+		channel = strings.TrimPrefix(strings.TrimSpace(channel), "#")
+		if channel != "" {
+			channels[channel] = true
+		}
+	}
+	return channels
+}
+
+// Build a filter from comma-separated lists of channel names or IDs.  A
+// leading # on a name is optional.
+func NewChannelFilter(include string, exclude string) ChannelFilter {
+	included := splitChannelList(include)
+	all := included["all"]
+	delete(included, "all")
+	return ChannelFilter{all: all, included: included, excluded: splitChannelList(exclude)}
+}
+
+func (f ChannelFilter) Tracks(channel slack.Channel) bool {
+	if !channel.IsMember {
+		return false
+	}
+
+	if f.excluded[channel.ID] || f.excluded[channel.Name] {
+		return false
+	}
+
+	return f.all || f.included[channel.ID] || f.included[channel.Name]
+}
+
+func (f ChannelFilter) empty() bool {
+	return !f.all && len(f.included) == 0
 }
 
 type Conversation struct {
@@ -14,40 +133,165 @@ type Conversation struct {
 	ConversationType string
 	DisplayName      string
 	LatestMsgTs      string
+	// the workspace the conversation is in, blank for conversations stored
+	// before slackbox followed more than one
+	TeamID string
+	// only set for threads and mentions, whose IDs are our own--see
+	// threadID and mentionID.  A mention's ThreadTs is that of the thread
+	// it's in, if any.
+	ChannelID string
+	ThreadTs  string
+}
+
+// The id slack knows the conversation's channel by.
+func (c Conversation) SlackChannelID() string {
+	if c.ChannelID != "" {
+		return c.ChannelID
+	}
+	return c.ID
+}
+
+// Conversation ids are only unique within a workspace, since channels shared
+// through Slack Connect keep the same id in each.
+type conversationKey struct {
+	teamID string
+	id     string
 }
 
-func ConnectAPI(token string) (*SlackBoxAPI, error) {
-	api := slack.New(token)
+func (c Conversation) key() conversationKey {
+	return conversationKey{teamID: c.TeamID, id: c.ID}
+}
+
+type Message struct {
+	Ts     string
+	UserID string
+	// who sent the message, as best we can name them
+	Author string
+	Text   string
+}
+
+type User struct {
+	ID          string
+	RealName    string
+	DisplayName string
+	IsBot       bool
+	Deleted     bool
+}
+
+// The best name we have for the user, preferring their real name as slack's
+// own DM list does.
+func (u User) Name() string {
+	if u.RealName != "" {
+		return u.RealName
+	}
 
-	_, err := api.AuthTest()
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+
+	return u.ID
+}
+
+func ConnectAPI(token string, opts APIOptions) (*SlackBoxAPI, error) {
+	apiURL := opts.APIURL
+	if apiURL == "" {
+		apiURL = slack.APIURL
+	}
+	api := slack.New(token, slack.OptionAPIURL(apiURL))
+
+	// check the token can do what's asked of it, before anything fails
+	// for lack of a scope
+	auth, scopes, err := authTest(apiURL, token)
 	if err != nil {
 		return nil, err
 	}
+	var missing []scopeRequirement
+	if scopes != nil {
+		missing = missingScopes(scopes, scopeRequirements(opts))
+	}
+	if !canStartWithout(missing) {
+		return nil, errors.New(scopeReport(auth.Team, missing))
+	}
+	for _, req := range missing {
+		switch req.feature {
+		case mentionsFeature:
+			opts.Mentions = false
+		case realtimeFeature:
+			opts.Realtime = false
+		}
+	}
 
 	teamInfo, err := api.GetTeamInfo()
 	if err != nil {
 		return nil, err
 	}
 
-	return &SlackBoxAPI{api, teamInfo.Name}, err
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &SlackBoxAPI{
+		client:         api,
+		apiURL:         apiURL,
+		token:          token,
+		teamID:         auth.TeamID,
+		teamName:       teamInfo.Name,
+		userID:         auth.UserID,
+		channels:       opts.Channels,
+		threads:        opts.Threads,
+		threadLookback: opts.ThreadLookback,
+		mentions:       opts.Mentions,
+		realtime:       opts.Realtime,
+		workers:        workers,
+		limiter:        newRateLimiter(nil),
+		users:          make(map[string]User),
+
+		missingScopes: missing,
+	}, err
 }
 
 func (api *SlackBoxAPI) FetchConversationLink(id string, ts string) (string, error) {
+	ctx := context.Background()
 	params := &slack.PermalinkParameters{Channel: id, Ts: ts}
-	return api.client.GetPermalink(params)
+
+	var link string
+	err := api.limiter.call(ctx, "chat.getPermalink", func() (err error) {
+		link, err = api.client.GetPermalinkContext(ctx, params)
+		return err
+	})
+	return link, err
 }
 
-func (api *SlackBoxAPI) recursiveFetchConversations(types []string) ([]slack.Channel, error) {
+// How many conversations to ask slack for in each page of
+// users.conversations.
+const conversationsPageSize = 200
+
+// List the user's own conversations of the types given, leaving out archived
+// channels.  Unlike conversations.list, which would page through every
+// public channel in the workspace, users.conversations only lists those the
+// user is in.
+func (api *SlackBoxAPI) recursiveFetchConversations(ctx context.Context, types []string) ([]slack.Channel, error) {
 	ims := make([]slack.Channel, 0)
-	params := &slack.GetConversationsParameters{Types: types}
+	params := &slack.GetConversationsForUserParameters{Types: types, Limit: conversationsPageSize, ExcludeArchived: true}
 
 	for {
-		newIms, nextCursor, err := api.client.GetConversations(params)
+		var newIms []slack.Channel
+		var nextCursor string
+		err := api.limiter.call(ctx, "users.conversations", func() (err error) {
+			newIms, nextCursor, err = api.client.GetConversationsForUserContext(ctx, params)
+			return err
+		})
 
 		if err != nil {
 			return ims, err
 		}
 
+		// every conversation listed is one the user is in, whether or not
+		// slack says so
+		for i := range newIms {
+			newIms[i].IsMember = true
+		}
 		ims = append(ims, newIms...)
 
 		if nextCursor == "" {
@@ -60,60 +304,492 @@ func (api *SlackBoxAPI) recursiveFetchConversations(types []string) ([]slack.Cha
 	return ims, nil
 }
 
-func (api *SlackBoxAPI) FetchConversations() ([]Conversation, error) {
-	conversations := make([]Conversation, 0)
+// Call fn with each index up to n from a pool of workers.  The first error,
+// or ctx being cancelled, stops the rest.
+func (api *SlackBoxAPI) forEach(ctx context.Context, n int, fn func(context.Context, int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < api.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// drain what's already been handed out without calling
+				// slack, once there's no point
+				if ctx.Err() != nil {
+					continue
+				}
+
+				err := fn(ctx, i)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
+
+// Fetch all tracked conversations, in the order slack lists them, each
+// followed by any threads newly found in it.  latestMsgTimestamps holds the
+// latest message ts already known for each conversation id, so only newer
+// history needs to be fetched.
+//
+// Conversations are fetched by a pool of workers; the first error, or ctx
+// being cancelled, stops the rest.
+func (api *SlackBoxAPI) FetchConversations(ctx context.Context, latestMsgTimestamps map[string]string) ([]Conversation, error) {
+	types := []string{"im", "mpim"}
+	if !api.channels.empty() {
+		types = append(types, "public_channel", "private_channel")
+	}
+
+	api.forgetUntracked()
+
+	channels, err := api.recursiveFetchConversations(ctx, types)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// each worker only writes its own indexes, so the results keep slack's
+	// order no matter which finishes first
+	fetched := make([][]Conversation, len(channels))
+	progress := api.newProgressCounter("conversations", len(channels))
+
+	err = api.forEach(ctx, len(channels), func(ctx context.Context, i int) (err error) {
+		fetched[i], err = api.toConversations(ctx, channels[i], latestMsgTimestamps)
+		progress()
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]Conversation, 0, len(channels))
+	for _, f := range fetched {
+		conversations = append(conversations, f...)
+	}
+
+	return conversations, nil
+}
+
+// Fetch a single conversation by id, e.g. one we've just seen a message in
+// for the first time.  The returned bool is false if the conversation is a
+// channel we aren't tracking.
+func (api *SlackBoxAPI) FetchConversation(ctx context.Context, id string) (Conversation, bool, error) {
+	if api.isUntracked(id) {
+		return Conversation{}, false, nil
+	}
+
+	var channel *slack.Channel
+	err := api.limiter.call(ctx, "conversations.info", func() (err error) {
+		channel, err = api.client.GetConversationInfoContext(ctx, id, false)
+		return err
+	})
+	if err != nil {
+		return Conversation{}, false, err
+	}
+
+	conversations, err := api.toConversations(ctx, *channel, nil)
+	if err != nil {
+		return Conversation{}, false, err
+	}
+	if len(conversations) == 0 {
+		api.markUntracked(id)
+		return Conversation{}, false, nil
+	}
+
+	return conversations[0], true, nil
+}
+
+func (api *SlackBoxAPI) isUntracked(id string) bool {
+	api.untrackedLock.Lock()
+	defer api.untrackedLock.Unlock()
+	return api.untracked[id]
+}
+
+func (api *SlackBoxAPI) markUntracked(id string) {
+	api.untrackedLock.Lock()
+	defer api.untrackedLock.Unlock()
+
+	if api.untracked == nil {
+		api.untracked = make(map[string]bool)
+	}
+	api.untracked[id] = true
+}
+
+func (api *SlackBoxAPI) forgetUntracked() {
+	api.untrackedLock.Lock()
+	defer api.untrackedLock.Unlock()
+	api.untracked = nil
+}
+
+// Turn the channel into a conversation, followed by any threads in its recent
+// history we should start following.  Returns nothing if the channel isn't
+// tracked.
+func (api *SlackBoxAPI) toConversations(ctx context.Context, channel slack.Channel, latestMsgTimestamps map[string]string) ([]Conversation, error) {
+	var conversation Conversation
+	var err error
+
+	switch {
+	case channel.IsIM:
+		conversation, err = api.imToConversation(ctx, channel.ID, channel.User)
+	case channel.IsMpIM:
+		conversation, err = api.mpimToConversation(ctx, channel.ID)
+	case api.channels.Tracks(channel):
+		conversation = channelToConversation(channel.ID, channel.Name)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	latestMsgTs, parents, err := api.fetchLatestAndThreadParents(ctx, channel.ID, latestMsgTimestamps[channel.ID])
+	if err != nil {
+		return nil, err
+	}
 
-	ims, err := api.recursiveFetchConversations([]string{"im", "mpim"})
+	conversation.LatestMsgTs = latestMsgTs
+	conversation.TeamID = api.teamID
 
+	threads, err := api.newThreads(ctx, conversation, parents, latestMsgTimestamps)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, im := range ims {
-		conversation, err := api.imToConversation(im.ID, im.User)
+	return append([]Conversation{conversation}, threads...), nil
+}
+
+// Fetch every user in the team with users.list, which is far cheaper than
+// looking them up one at a time.
+func (api *SlackBoxAPI) FetchUsers(ctx context.Context) ([]User, error) {
+	users := make([]User, 0)
+	pages := api.client.GetUsersPaginated()
+
+	for {
+		var next slack.UserPagination
+		done := false
+		err := api.limiter.call(ctx, "users.list", func() (err error) {
+			next, err = pages.Next(ctx)
+			done = pages.Done(err)
+			return pages.Failure(err)
+		})
+
 		if err != nil {
-			return nil, err
+			return users, err
 		}
 
-		conversations = append(conversations, conversation)
+		if done {
+			break
+		}
+
+		for _, u := range next.Users {
+			users = append(users, toUser(u))
+		}
+
+		pages = next
 	}
 
-	return conversations, nil
+	return users, nil
+}
+
+func toUser(u slack.User) User {
+	return User{
+		ID:          u.ID,
+		RealName:    u.RealName,
+		DisplayName: u.Profile.DisplayName,
+		IsBot:       u.IsBot,
+		Deleted:     u.Deleted,
+	}
+}
+
+// Use users, e.g. from the db, to name conversations rather than looking up
+// each user in slack.
+func (api *SlackBoxAPI) SetUsers(users map[string]User) {
+	api.usersLock.Lock()
+	defer api.usersLock.Unlock()
+
+	for id, user := range users {
+		api.users[id] = user
+	}
+}
+
+// The user's name from the directory, or their id if they aren't in it.
+// Unlike fetchUserName, never goes to slack.
+func (api *SlackBoxAPI) UserName(id string) string {
+	api.usersLock.RLock()
+	defer api.usersLock.RUnlock()
+
+	if user, ok := api.users[id]; ok {
+		return user.Name()
+	}
+
+	return id
 }
 
-func (api *SlackBoxAPI) fetchUserName(imUser string) (string, error) {
-	user, err := api.client.GetUserInfo(imUser)
+func (api *SlackBoxAPI) fetchUserName(ctx context.Context, imUser string) (string, error) {
+	api.usersLock.RLock()
+	known, ok := api.users[imUser]
+	api.usersLock.RUnlock()
+
+	if ok {
+		return known.Name(), nil
+	}
+
+	var user *slack.User
+	err := api.limiter.call(ctx, "users.info", func() (err error) {
+		user, err = api.client.GetUserInfoContext(ctx, imUser)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
-	return user.RealName, nil
+
+	known = toUser(*user)
+
+	api.usersLock.Lock()
+	api.users[imUser] = known
+	api.usersLock.Unlock()
+
+	return known.Name(), nil
+}
+
+func (api *SlackBoxAPI) recursiveFetchMembers(ctx context.Context, conversationID string) ([]string, error) {
+	members := make([]string, 0)
+	params := &slack.GetUsersInConversationParameters{ChannelID: conversationID}
+
+	for {
+		var newMembers []string
+		var nextCursor string
+		err := api.limiter.call(ctx, "conversations.members", func() (err error) {
+			newMembers, nextCursor, err = api.client.GetUsersInConversationContext(ctx, params)
+			return err
+		})
+
+		if err != nil {
+			return members, err
+		}
+
+		members = append(members, newMembers...)
+
+		if nextCursor == "" {
+			break
+		}
+
+		params.Cursor = nextCursor
+	}
+
+	return members, nil
+}
+
+// Group DMs have no single user, so name them after everyone in them except
+// ourselves, e.g. "alice, bob, carol".
+func (api *SlackBoxAPI) fetchMpimName(ctx context.Context, mpimID string) (string, error) {
+	members, err := api.recursiveFetchMembers(ctx, mpimID)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		if member == api.userID {
+			continue
+		}
+
+		name, err := api.fetchUserName(ctx, member)
+		if err != nil {
+			return "", err
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", "), nil
+}
+
+// Set a func to be called with a description of anything slowing down calls
+// to slack, e.g. being rate limited.  It may be called from any goroutine.
+func (api *SlackBoxAPI) OnProgress(progress func(string)) {
+	api.limiter.progress = progress
+}
+
+// Returns a func to call as each of total things is fetched, which reports
+// e.g. "Fetched 42/180 conversations".  Safe to call from any goroutine.
+func (api *SlackBoxAPI) newProgressCounter(things string, total int) func() {
+	var fetched int32
+	api.limiter.progress(fmt.Sprintf("Fetched 0/%d %s", total, things))
+	return func() {
+		n := atomic.AddInt32(&fetched, 1)
+		api.limiter.progress(fmt.Sprintf("Fetched %d/%d %s", n, total, things))
+	}
+}
+
+func (api *SlackBoxAPI) TeamID() string {
+	return api.teamID
 }
 
 func (api *SlackBoxAPI) TeamName() string {
 	return api.teamName
 }
 
-func (api *SlackBoxAPI) imToConversation(imID string, imUser string) (Conversation, error) {
+func (api *SlackBoxAPI) FollowsThreads() bool {
+	return api.threads
+}
+
+func (api *SlackBoxAPI) imToConversation(ctx context.Context, imID string, imUser string) (Conversation, error) {
 	convo := Conversation{ConversationType: "im", ID: imID}
-	userName, err := api.fetchUserName(imUser)
+	userName, err := api.fetchUserName(ctx, imUser)
 	if err != nil {
 		return Conversation{}, err
 	}
 
 	convo.DisplayName = userName
+	return convo, nil
+}
+
+func (api *SlackBoxAPI) mpimToConversation(ctx context.Context, mpimID string) (Conversation, error) {
+	convo := Conversation{ConversationType: "mpim", ID: mpimID}
+	name, err := api.fetchMpimName(ctx, mpimID)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	convo.DisplayName = name
+	return convo, nil
+}
+
+func channelToConversation(channelID string, channelName string) Conversation {
+	return Conversation{ConversationType: "channel", ID: channelID, DisplayName: "#" + channelName}
+}
 
-	params := &slack.GetConversationHistoryParameters{ChannelID: imID}
-	history, err := api.client.GetConversationHistory(params)
+// Find the ts of the newest message in the conversation, asking only for
+// messages after knownLatestMsgTs.  If there are none, knownLatestMsgTs is
+// still the latest.
+func (api *SlackBoxAPI) fetchLatestMsgTs(ctx context.Context, conversationID string, knownLatestMsgTs string) (string, error) {
+	latestMsgTs := knownLatestMsgTs
+
+	// history comes back newest first, so one message is all we need
+	params := &slack.GetConversationHistoryParameters{ChannelID: conversationID, Oldest: knownLatestMsgTs, Limit: 1}
+
+	var history *slack.GetConversationHistoryResponse
+	err := api.limiter.call(ctx, "conversations.history", func() (err error) {
+		history, err = api.client.GetConversationHistoryContext(ctx, params)
+		return err
+	})
 
 	if err != nil {
-		return convo, err
+		return latestMsgTs, err
 	}
 
 	for _, msg := range history.Messages {
-		if msg.Timestamp > convo.LatestMsgTs {
-			convo.LatestMsgTs = msg.Timestamp
+		if msg.Timestamp > latestMsgTs {
+			latestMsgTs = msg.Timestamp
 		}
 	}
 
-	return convo, nil
+	return latestMsgTs, nil
+}
+
+// Fetch up to limit of the newest messages in the conversation after oldest
+// (or from the beginning if oldest is blank), returned oldest first.  If
+// threadTs is set, the messages are the replies in that thread instead.
+func (api *SlackBoxAPI) FetchMessages(ctx context.Context, conversationID string, threadTs string, oldest string, limit int) ([]Message, error) {
+	var msgs []slack.Message
+	var err error
+
+	if threadTs != "" {
+		msgs, err = api.fetchReplies(ctx, conversationID, threadTs, oldest, limit)
+	} else {
+		params := &slack.GetConversationHistoryParameters{ChannelID: conversationID, Oldest: oldest, Limit: limit}
+
+		var history *slack.GetConversationHistoryResponse
+		err = api.limiter.call(ctx, "conversations.history", func() (err error) {
+			history, err = api.client.GetConversationHistoryContext(ctx, params)
+			return err
+		})
+
+		if err == nil {
+			// history comes back newest first
+			msgs = make([]slack.Message, len(history.Messages))
+			for i, msg := range history.Messages {
+				msgs[len(msgs)-1-i] = msg
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(msgs))
+
+	for _, msg := range msgs {
+		author, err := api.messageAuthor(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, Message{Ts: msg.Timestamp, UserID: msg.User, Author: author, Text: msg.Text})
+	}
+
+	return messages, nil
+}
+
+func (api *SlackBoxAPI) messageAuthor(ctx context.Context, msg slack.Message) (string, error) {
+	switch {
+	case msg.User != "":
+		return api.fetchUserName(ctx, msg.User)
+	case msg.Username != "":
+		return msg.Username, nil
+	case msg.BotProfile != nil:
+		return msg.BotProfile.Name, nil
+	default:
+		return "unknown", nil
+	}
+}
+
+// Post text to the conversation, as a reply in the thread started by threadTs
+// unless it's blank.  Returns the ts of the posted message.
+func (api *SlackBoxAPI) PostMessage(ctx context.Context, conversationID string, text string, threadTs string) (string, error) {
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if threadTs != "" {
+		options = append(options, slack.MsgOptionTS(threadTs))
+	}
+
+	var ts string
+	err := api.limiter.callOnce(ctx, "chat.postMessage", func() (err error) {
+		_, ts, err = api.client.PostMessageContext(ctx, conversationID, options...)
+		return err
+	})
+
+	return ts, err
 }
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type fakeHandler func(form url.Values) (int, interface{})
+
+// An http server standing in for slack's web api.  Each method answers with
+// its handler, and every call's form is kept for checking.
+type fakeSlackServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers map[string]fakeHandler
+	calls    map[string][]url.Values
+	// sent as the X-OAuth-Scopes header of every reply, unless nil
+	scopes []string
+}
+
+func newFakeSlackServer(t *testing.T) *fakeSlackServer {
+	s := &fakeSlackServer{handlers: make(map[string]fakeHandler), calls: make(map[string][]url.Values)}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			t.Errorf("Failed parsing request %s", err)
+		}
+
+		method := strings.TrimPrefix(r.URL.Path, "/")
+
+		s.mu.Lock()
+		s.calls[method] = append(s.calls[method], r.Form)
+		handler, found := s.handlers[method]
+		scopes := s.scopes
+		s.mu.Unlock()
+
+		status, body := http.StatusOK, interface{}(map[string]interface{}{"ok": false, "error": "unknown_method"})
+		if found {
+			status, body = handler(r.Form)
+		} else {
+			t.Errorf("Unexpected call to %s", method)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if scopes != nil {
+			w.Header().Set("X-OAuth-Scopes", strings.Join(scopes, ","))
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+
+	return s
+}
+
+func (s *fakeSlackServer) handle(method string, handler func(form url.Values) interface{}) {
+	s.handleStatus(method, func(form url.Values) (int, interface{}) {
+		return http.StatusOK, handler(form)
+	})
+}
+
+func (s *fakeSlackServer) handleStatus(method string, handler fakeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = handler
+}
+
+func (s *fakeSlackServer) callsTo(method string) []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// An api talking to the server as user UME, which never really sleeps
+// between retries.
+func (s *fakeSlackServer) api(opts APIOptions) *SlackBoxAPI {
+	limiter := newRateLimiter(nil)
+	limiter.sleep = func(context.Context, time.Duration) error {
+		return nil
+	}
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &SlackBoxAPI{
+		client:         slack.New("ABCDEFG", slack.OptionAPIURL(s.URL+"/")),
+		apiURL:         s.URL + "/",
+		token:          "ABCDEFG",
+		teamID:         "T1",
+		teamName:       "fake team",
+		userID:         "UME",
+		channels:       opts.Channels,
+		threads:        opts.Threads,
+		threadLookback: opts.ThreadLookback,
+		mentions:       opts.Mentions,
+		workers:        workers,
+		limiter:        limiter,
+		users:          make(map[string]User),
+	}
+}
+
+func ok(fields map[string]interface{}) map[string]interface{} {
+	fields["ok"] = true
+	return fields
+}
+
+func nextCursor(cursor string) map[string]interface{} {
+	return map[string]interface{}{"next_cursor": cursor}
+}
+
+func fakeUser(id string, realName string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "name": strings.ToLower(realName), "real_name": realName}
+}
+
+func fakeMessage(user string, text string, ts string) map[string]interface{} {
+	return map[string]interface{}{"type": "message", "user": user, "text": text, "ts": ts}
+}
+
+// Serve two pages of IMs, with alice and bob, whose histories are given.
+func handleIMs(s *fakeSlackServer, histories map[string][]interface{}) {
+	s.handle("users.conversations", func(form url.Values) interface{} {
+		if form.Get("cursor") == "" {
+			return ok(map[string]interface{}{
+				"channels":          []interface{}{map[string]interface{}{"id": "D1", "is_im": true, "user": "U1"}},
+				"response_metadata": nextCursor("page2"),
+			})
+		}
+		return ok(map[string]interface{}{
+			"channels":          []interface{}{map[string]interface{}{"id": "D2", "is_im": true, "user": "U2"}},
+			"response_metadata": nextCursor(""),
+		})
+	})
+
+	s.handle("users.info", func(form url.Values) interface{} {
+		names := map[string]string{"U1": "Alice", "U2": "Bob"}
+		return ok(map[string]interface{}{"user": fakeUser(form.Get("user"), names[form.Get("user")])})
+	})
+
+	s.handle("conversations.history", func(form url.Values) interface{} {
+		messages := histories[form.Get("channel")]
+		if messages == nil {
+			messages = []interface{}{}
+		}
+		return ok(map[string]interface{}{"messages": messages, "has_more": false})
+	})
+}
+
+func TestChannelFilterTracks(t *testing.T) {
+	general := slack.Channel{}
+	general.ID, general.Name, general.IsMember = "C1", "general", true
+	random := slack.Channel{}
+	random.ID, random.Name, random.IsMember = "C2", "random", true
+	notJoined := slack.Channel{}
+	notJoined.ID, notJoined.Name = "C3", "announcements"
+
+	cases := []struct {
+		include  string
+		exclude  string
+		channel  slack.Channel
+		expected bool
+	}{
+		// nothing is tracked by default
+		{"", "", general, false},
+		{"general", "", general, true},
+		{"#general", "", general, true},
+		{"C1", "", general, true},
+		{"general", "", random, false},
+		{" general , random ", "", random, true},
+		{"all", "", general, true},
+		{"all", "", random, true},
+		{"all", "random", random, false},
+		{"all", "#random", random, false},
+		{"all", "C2", random, false},
+		{"all", "random", general, true},
+		// excluding wins over including
+		{"general", "general", general, false},
+		{"C1", "general", general, false},
+		// only channels we're a member of
+		{"all", "", notJoined, false},
+		{"announcements", "", notJoined, false},
+		{"C3", "", notJoined, false},
+	}
+
+	for _, c := range cases {
+		tracked := NewChannelFilter(c.include, c.exclude).Tracks(c.channel)
+		if tracked != c.expected {
+			t.Errorf("Including %q and excluding %q, expected tracking %s to be %v, got %v", c.include, c.exclude, c.channel.Name, c.expected, tracked)
+		}
+	}
+}
+
+func TestChannelFilterEmpty(t *testing.T) {
+	cases := []struct {
+		include  string
+		expected bool
+	}{
+		{"", true},
+		{" , ", true},
+		{"general", false},
+		{"all", false},
+	}
+
+	for _, c := range cases {
+		if empty := NewChannelFilter(c.include, "random").empty(); empty != c.expected {
+			t.Errorf("Including %q expected empty to be %v, got %v", c.include, c.expected, empty)
+		}
+	}
+}
+
+func TestFetchConversationsPaginates(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	handleIMs(s, map[string][]interface{}{
+		"D1": {fakeMessage("U1", "hi", "5.000000")},
+	})
+
+	api := s.api(APIOptions{Workers: 2})
+	conversations, err := api.FetchConversations(context.Background(), map[string]string{"D2": "3.000000"})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+
+	expected := []Conversation{
+		{ID: "D1", ConversationType: "im", DisplayName: "Alice", LatestMsgTs: "5.000000", TeamID: "T1"},
+		// nothing new in bob's history, so the latest is still what we knew
+		{ID: "D2", ConversationType: "im", DisplayName: "Bob", LatestMsgTs: "3.000000", TeamID: "T1"},
+	}
+	if !reflect.DeepEqual(expected, conversations) {
+		t.Errorf("Expected conversations %v, got %v", expected, conversations)
+	}
+
+	if len(s.callsTo("users.conversations")) != 2 {
+		t.Errorf("Expected 2 pages of conversations, got %d", len(s.callsTo("users.conversations")))
+	}
+	for _, form := range s.callsTo("users.conversations") {
+		if form.Get("exclude_archived") != "true" || form.Get("limit") != strconv.Itoa(conversationsPageSize) {
+			t.Errorf("Expected pages of %d unarchived conversations, got %v", conversationsPageSize, form)
+		}
+	}
+
+	for _, form := range s.callsTo("conversations.history") {
+		if form.Get("channel") == "D2" && form.Get("oldest") != "3.000000" {
+			t.Errorf("Expected bob's history fetched after 3.000000, got %s", form.Get("oldest"))
+		}
+	}
+}
+
+func TestFetchConversationsNamesMpims(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("users.conversations", func(url.Values) interface{} {
+		return ok(map[string]interface{}{
+			"channels": []interface{}{map[string]interface{}{"id": "G1", "is_mpim": true, "name": "mpdm-alice--bob--me-1"}},
+		})
+	})
+	s.handle("conversations.members", func(form url.Values) interface{} {
+		if form.Get("cursor") == "" {
+			return ok(map[string]interface{}{"members": []string{"U2", "UME"}, "response_metadata": nextCursor("page2")})
+		}
+		return ok(map[string]interface{}{"members": []string{"U1"}, "response_metadata": nextCursor("")})
+	})
+	s.handle("users.info", func(form url.Values) interface{} {
+		names := map[string]string{"U1": "Alice", "U2": "Bob"}
+		if form.Get("user") == "UME" {
+			t.Errorf("Expected our own name never looked up")
+		}
+		return ok(map[string]interface{}{"user": fakeUser(form.Get("user"), names[form.Get("user")])})
+	})
+	s.handle("conversations.history", func(url.Values) interface{} {
+		return ok(map[string]interface{}{"messages": []interface{}{fakeMessage("U1", "hi all", "4.000000")}, "has_more": false})
+	})
+
+	conversations, err := s.api(APIOptions{}).FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+
+	// named after everyone but us, in order, across pages of members
+	expected := []Conversation{{ID: "G1", ConversationType: "mpim", DisplayName: "Alice, Bob", LatestMsgTs: "4.000000", TeamID: "T1"}}
+	if !reflect.DeepEqual(expected, conversations) {
+		t.Errorf("Expected conversations %v, got %v", expected, conversations)
+	}
+}
+
+func TestFetchConversationsEmptyHistory(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	handleIMs(s, map[string][]interface{}{})
+
+	conversations, err := s.api(APIOptions{}).FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+
+	for _, c := range conversations {
+		if c.LatestMsgTs != "" {
+			t.Errorf("Expected no latest message in %s, got %s", c.ID, c.LatestMsgTs)
+		}
+	}
+	if len(conversations) != 2 {
+		t.Errorf("Expected 2 conversations, got %d", len(conversations))
+	}
+}
+
+func TestFetchConversationsNone(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("users.conversations", func(url.Values) interface{} {
+		return ok(map[string]interface{}{"channels": []interface{}{}})
+	})
+
+	conversations, err := s.api(APIOptions{}).FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+	if conversations == nil || len(conversations) != 0 {
+		t.Errorf("Expected no conversations, got %v", conversations)
+	}
+}
+
+func TestFetchConversationsAPIError(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	handleIMs(s, nil)
+	s.handle("conversations.history", func(url.Values) interface{} {
+		return map[string]interface{}{"ok": false, "error": "channel_not_found"}
+	})
+
+	_, err := s.api(APIOptions{}).FetchConversations(context.Background(), map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("Expected channel_not_found error, got %v", err)
+	}
+
+	// it isn't worth retrying
+	if len(s.callsTo("conversations.history")) != 1 {
+		t.Errorf("Expected 1 call for history, got %d", len(s.callsTo("conversations.history")))
+	}
+}
+
+func TestServerErrorsAreRetried(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	handleIMs(s, nil)
+	calls := 0
+	s.handleStatus("users.info", func(form url.Values) (int, interface{}) {
+		calls++
+		if calls == 1 {
+			return http.StatusInternalServerError, map[string]interface{}{}
+		}
+		return http.StatusOK, ok(map[string]interface{}{"user": fakeUser(form.Get("user"), "Alice")})
+	})
+
+	conversations, err := s.api(APIOptions{}).FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+	if len(conversations) != 2 || conversations[0].DisplayName != "Alice" {
+		t.Errorf("Expected alice's conversation after retrying, got %v", conversations)
+	}
+}
+
+func TestFetchUsersPaginates(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("users.list", func(form url.Values) interface{} {
+		if form.Get("cursor") == "" {
+			return ok(map[string]interface{}{
+				"members":           []interface{}{fakeUser("U1", "Alice")},
+				"response_metadata": nextCursor("page2"),
+			})
+		}
+		return ok(map[string]interface{}{
+			"members":           []interface{}{fakeUser("U2", "Bob")},
+			"response_metadata": nextCursor(""),
+		})
+	})
+
+	users, err := s.api(APIOptions{}).FetchUsers(context.Background())
+	if err != nil {
+		t.Fatalf("FetchUsers failed with error %s", err)
+	}
+
+	expected := []User{{ID: "U1", RealName: "Alice"}, {ID: "U2", RealName: "Bob"}}
+	if !reflect.DeepEqual(expected, users) {
+		t.Errorf("Expected users %v, got %v", expected, users)
+	}
+}
+
+func TestFetchThread(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("conversations.replies", func(form url.Values) interface{} {
+		if form.Get("cursor") == "" {
+			return ok(map[string]interface{}{
+				"messages":          []interface{}{fakeMessage("UME", "lunch?", "1.000000"), fakeMessage("U1", "yes", "2.000000")},
+				"has_more":          true,
+				"response_metadata": nextCursor("page2"),
+			})
+		}
+		return ok(map[string]interface{}{
+			"messages": []interface{}{fakeMessage("U2", "me too", "3.000000")},
+			"has_more": false,
+		})
+	})
+
+	api := s.api(APIOptions{Threads: true})
+	parent := Conversation{ID: "D1", ConversationType: "im", DisplayName: "alice", TeamID: "T1"}
+	thread, err := api.FetchThread(context.Background(), newThread(parent, "1.000000"), parent.DisplayName)
+	if err != nil {
+		t.Fatalf("FetchThread failed with error %s", err)
+	}
+
+	expected := Conversation{
+		ID:               "D1/1.000000",
+		ConversationType: "thread",
+		DisplayName:      "alice › lunch?",
+		LatestMsgTs:      "3.000000",
+		TeamID:           "T1",
+		ChannelID:        "D1",
+		ThreadTs:         "1.000000",
+	}
+	if thread != expected {
+		t.Errorf("Expected thread %v, got %v", expected, thread)
+	}
+}
+
+func TestFetchMessagesKeepsNewestReplies(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("conversations.replies", func(form url.Values) interface{} {
+		if form.Get("cursor") == "" {
+			return ok(map[string]interface{}{
+				"messages":          []interface{}{fakeMessage("UME", "lunch?", "1.000000"), fakeMessage("U1", "yes", "2.000000")},
+				"has_more":          true,
+				"response_metadata": nextCursor("page2"),
+			})
+		}
+		return ok(map[string]interface{}{
+			"messages": []interface{}{fakeMessage("U2", "me too", "3.000000"), fakeMessage("U1", "now?", "4.000000")},
+			"has_more": false,
+		})
+	})
+	s.handle("users.info", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{"user": fakeUser(form.Get("user"), form.Get("user"))})
+	})
+
+	api := s.api(APIOptions{Threads: true})
+	messages, err := api.FetchMessages(context.Background(), "D1", "1.000000", "", 2)
+	if err != nil {
+		t.Fatalf("FetchMessages failed with error %s", err)
+	}
+
+	timestamps := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		timestamps = append(timestamps, msg.Ts)
+	}
+	if expected := []string{"3.000000", "4.000000"}; !reflect.DeepEqual(expected, timestamps) {
+		t.Errorf("Expected the newest replies %v, got %v", expected, timestamps)
+	}
+}
+
+func TestConnectAPIChecksScopes(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	s.handle("auth.test", func(url.Values) interface{} {
+		return ok(map[string]interface{}{"team": "fake team", "team_id": "T1", "user_id": "UME"})
+	})
+	s.handle("team.info", func(url.Values) interface{} {
+		return ok(map[string]interface{}{"team": map[string]interface{}{"id": "T1", "name": "fake team"}})
+	})
+	opts := APIOptions{Mentions: true, APIURL: s.URL + "/"}
+
+	s.scopes = []string{"im:read", "im:history", "mpim:read", "mpim:history", "users:read", "team:read", "search:read", "chat:write"}
+	api, err := ConnectAPI("ABCDEFG", opts)
+	if err != nil {
+		t.Fatalf("ConnectAPI failed with error %s", err)
+	}
+	if api.TeamID() != "T1" || len(api.missingScopes) != 0 {
+		t.Errorf("Expected T1 connected with every scope, got %s missing %v", api.TeamID(), api.missingScopes)
+	}
+
+	// without history, nothing works, so it's not worth starting
+	s.scopes = []string{"im:read", "mpim:read", "users:read", "team:read", "search:read"}
+	_, err = ConnectAPI("ABCDEFG", opts)
+	if err == nil || !strings.Contains(err.Error(), "Reading direct messages needs im:history, mpim:history") {
+		t.Errorf("Expected an error explaining the missing history scopes, got %v", err)
+	}
+	if len(s.callsTo("team.info")) != 1 {
+		t.Errorf("Expected team.info left uncalled once scopes were missing, got %d calls", len(s.callsTo("team.info")))
+	}
+
+	// replies failing isn't worth refusing to start for
+	s.scopes = []string{"im:read", "im:history", "mpim:read", "mpim:history", "users:read", "team:read", "search:read"}
+	api, err = ConnectAPI("ABCDEFG", opts)
+	if err != nil {
+		t.Fatalf("ConnectAPI failed with error %s", err)
+	}
+	if len(api.missingScopes) != 1 || api.missingScopes[0].feature != "Replying" {
+		t.Errorf("Expected only replying missing, got %v", api.missingScopes)
+	}
+
+	// nor are mentions, which are skipped instead
+	s.scopes = []string{"im:read", "im:history", "mpim:read", "mpim:history", "users:read", "team:read", "chat:write"}
+	api, err = ConnectAPI("ABCDEFG", opts)
+	if err != nil {
+		t.Fatalf("ConnectAPI failed with error %s", err)
+	}
+	if len(api.missingScopes) != 1 || api.missingScopes[0].feature != mentionsFeature || api.mentions {
+		t.Errorf("Expected mentions missing and turned off, got %v %v", api.missingScopes, api.mentions)
+	}
+
+	// some legacy tokens don't say what they can do, so they get the
+	// benefit of the doubt
+	s.scopes = nil
+	api, err = ConnectAPI("ABCDEFG", opts)
+	if err != nil {
+		t.Fatalf("ConnectAPI failed with error %s", err)
+	}
+	if len(api.missingScopes) != 0 {
+		t.Errorf("Expected nothing missing without scopes, got %v", api.missingScopes)
+	}
+
+	// the scopes come from the same call that says who the token is for
+	if api.userID != "UME" || len(s.callsTo("auth.test")) != 5 {
+		t.Errorf("Expected UME from one auth.test per connect, got %s from %d calls", api.userID, len(s.callsTo("auth.test")))
+	}
+
+	s.handle("auth.test", func(url.Values) interface{} {
+		return map[string]interface{}{"ok": false, "error": "token_revoked"}
+	})
+	_, err = ConnectAPI("ABCDEFG", opts)
+	if err == nil || !strings.Contains(err.Error(), "token_revoked") {
+		t.Errorf("Expected an error saying the token was revoked, got %v", err)
+	}
+}
+
+func TestFetchConversationRemembersUntracked(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	handleIMs(s, nil)
+	s.handle("conversations.info", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{
+			"channel": map[string]interface{}{"id": form.Get("channel"), "name": "random", "is_channel": true, "is_member": true},
+		})
+	})
+
+	api := s.api(APIOptions{Channels: NewChannelFilter("general", "")})
+	for i := 0; i < 3; i++ {
+		_, tracked, err := api.FetchConversation(context.Background(), "C2")
+		if err != nil || tracked {
+			t.Errorf("Expected C2 untracked, got %v %v", tracked, err)
+		}
+	}
+	if len(s.callsTo("conversations.info")) != 1 {
+		t.Errorf("Expected 1 call to conversations.info, got %d", len(s.callsTo("conversations.info")))
+	}
+
+	// refreshing forgets, in case the channel's been joined since
+	_, err := api.FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+	_, _, err = api.FetchConversation(context.Background(), "C2")
+	if err != nil {
+		t.Fatalf("FetchConversation failed with error %s", err)
+	}
+	if len(s.callsTo("conversations.info")) != 2 {
+		t.Errorf("Expected C2 looked up again after refresh, got %d calls", len(s.callsTo("conversations.info")))
+	}
+}
+
+// Serve n IMs, D0 to Dn-1, with user Ui in Di, whose histories take longer
+// to fetch the earlier they're listed, so workers finish them out of order.
+func handleSlowIMs(s *fakeSlackServer, n int, onHistory func()) {
+	s.handle("users.conversations", func(url.Values) interface{} {
+		channels := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			channels = append(channels, map[string]interface{}{"id": fmt.Sprintf("D%d", i), "is_im": true, "user": fmt.Sprintf("U%d", i)})
+		}
+		return ok(map[string]interface{}{"channels": channels})
+	})
+
+	s.handle("users.info", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{"user": fakeUser(form.Get("user"), "User "+form.Get("user"))})
+	})
+
+	s.handle("conversations.history", func(form url.Values) interface{} {
+		onHistory()
+		var i int
+		fmt.Sscanf(form.Get("channel"), "D%d", &i)
+		time.Sleep(time.Duration(n-i) * 10 * time.Millisecond)
+		return ok(map[string]interface{}{"messages": []interface{}{fakeMessage("U1", "hi", fmt.Sprintf("%d.000000", i+1))}, "has_more": false})
+	})
+}
+
+func TestFetchConversationsKeepsOrderWithWorkers(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	handleSlowIMs(s, 6, func() {})
+
+	conversations, err := s.api(APIOptions{Workers: 3}).FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+
+	ids := make([]string, 0, len(conversations))
+	for _, c := range conversations {
+		ids = append(ids, c.ID)
+	}
+	expected := []string{"D0", "D1", "D2", "D3", "D4", "D5"}
+	if !reflect.DeepEqual(expected, ids) {
+		t.Errorf("Expected conversations in slack's order %v, got %v", expected, ids)
+	}
+}
+
+func TestFetchConversationsStopsWhenCancelled(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSlowIMs(s, 20, cancel)
+
+	workers := 3
+	_, err := s.api(APIOptions{Workers: workers}).FetchConversations(ctx, map[string]string{})
+	if err == nil {
+		t.Fatal("Expected an error once cancelled")
+	}
+
+	// only what was already in flight when the first history cancelled
+	// it, even once requests the client gave up on have reached the server
+	time.Sleep(100 * time.Millisecond)
+	if calls := len(s.callsTo("conversations.history")); calls > workers {
+		t.Errorf("Expected at most %d history calls, got %d", workers, calls)
+	}
+}
+
+func TestPostMessageIsNotRetried(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	// slack may well have posted before failing
+	s.handleStatus("chat.postMessage", func(url.Values) (int, interface{}) {
+		return http.StatusInternalServerError, map[string]interface{}{}
+	})
+
+	_, err := s.api(APIOptions{}).PostMessage(context.Background(), "D1", "hi", "")
+	if err == nil {
+		t.Error("Expected the server error")
+	}
+	if len(s.callsTo("chat.postMessage")) != 1 {
+		t.Errorf("Expected 1 post, got %d", len(s.callsTo("chat.postMessage")))
+	}
+
+	s.handle("chat.postMessage", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{"channel": form.Get("channel"), "ts": "5.000000"})
+	})
+	ts, err := s.api(APIOptions{}).PostMessage(context.Background(), "D1", "hi", "1.000000")
+	if err != nil {
+		t.Fatalf("PostMessage failed with error %s", err)
+	}
+	posted := s.callsTo("chat.postMessage")[1]
+	if ts != "5.000000" || posted.Get("text") != "hi" || posted.Get("thread_ts") != "1.000000" {
+		t.Errorf("Expected hi posted in thread 1.000000 as 5.000000, got %v as %s", posted, ts)
+	}
+}
+
+func threadedMessage(user string, text string, ts string, latestReply string, replyUsers ...string) map[string]interface{} {
+	msg := fakeMessage(user, text, ts)
+	msg["thread_ts"] = ts
+	msg["reply_count"] = len(replyUsers)
+	msg["reply_users"] = replyUsers
+	msg["latest_reply"] = latestReply
+	return msg
+}
+
+func TestFetchConversationsFindsThreads(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	now := time.Now()
+	ago := func(d time.Duration) string {
+		return timeToSlackTs(now.Add(-d))
+	}
+
+	// newest first, as slack has them
+	histories := map[string][]map[string]interface{}{
+		"D1": {
+			fakeMessage("U1", "since", ago(30*time.Minute)),
+			threadedMessage("U1", "lunch?", ago(2*time.Hour), ago(time.Hour), "UME", "U1"),
+		},
+		// quiet since before the lookback, so not worth scanning for new
+		// replies to
+		"D2": {
+			threadedMessage("U2", "old news", ago(48*time.Hour), ago(time.Hour), "U2"),
+		},
+		"C1": {
+			fakeMessage("U1", "since", ago(time.Hour)),
+			threadedMessage("U2", "followed", ago(2*time.Hour), ago(90*time.Minute), "U1"),
+			threadedMessage("U1", "not ours", ago(3*time.Hour), ago(2*time.Hour), "U2"),
+			threadedMessage("U1", "replied in", ago(4*time.Hour), ago(3*time.Hour), "U2", "UME"),
+			threadedMessage("UME", "started", ago(5*time.Hour), ago(4*time.Hour), "U1"),
+		},
+	}
+
+	s.handle("users.conversations", func(form url.Values) interface{} {
+		return ok(map[string]interface{}{
+			"channels": []interface{}{
+				map[string]interface{}{"id": "D1", "is_im": true, "user": "U1"},
+				map[string]interface{}{"id": "D2", "is_im": true, "user": "U2"},
+				map[string]interface{}{"id": "C1", "name": "general", "is_channel": true},
+			},
+			"response_metadata": nextCursor(""),
+		})
+	})
+	s.handle("users.info", func(form url.Values) interface{} {
+		names := map[string]string{"U1": "Alice", "U2": "Bob"}
+		return ok(map[string]interface{}{"user": fakeUser(form.Get("user"), names[form.Get("user")])})
+	})
+	s.handle("conversations.history", func(form url.Values) interface{} {
+		limit, _ := strconv.Atoi(form.Get("limit"))
+		messages := make([]interface{}, 0)
+		for _, msg := range histories[form.Get("channel")] {
+			if msg["ts"].(string) > form.Get("oldest") && (limit == 0 || len(messages) < limit) {
+				messages = append(messages, msg)
+			}
+		}
+		return ok(map[string]interface{}{"messages": messages, "has_more": false})
+	})
+
+	// C1 was last seen active inside the lookback, so its scan finds what's
+	// newest in it without asking for one message first
+	latestMsgTimestamps := map[string]string{
+		"D2":                             ago(48 * time.Hour),
+		"C1":                             ago(2 * time.Hour),
+		threadID("C1", ago(2*time.Hour)): ago(90 * time.Minute),
+	}
+
+	api := s.api(APIOptions{Channels: NewChannelFilter("general", ""), Threads: true, ThreadLookback: 24 * time.Hour})
+	conversations, err := api.FetchConversations(context.Background(), latestMsgTimestamps)
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+
+	found := make(map[string]Conversation)
+	for _, c := range conversations {
+		found[c.ID] = c
+	}
+
+	expected := map[string]Conversation{
+		"D1": {ID: "D1", ConversationType: "im", DisplayName: "Alice", LatestMsgTs: ago(30 * time.Minute), TeamID: "T1"},
+		// a reply to an older message makes the thread new to follow
+		threadID("D1", ago(2*time.Hour)): {
+			ID: threadID("D1", ago(2*time.Hour)), ConversationType: "thread", DisplayName: "Alice › lunch?",
+			LatestMsgTs: ago(time.Hour), TeamID: "T1", ChannelID: "D1", ThreadTs: ago(2 * time.Hour),
+		},
+		"D2": {ID: "D2", ConversationType: "im", DisplayName: "Bob", LatestMsgTs: ago(48 * time.Hour), TeamID: "T1"},
+		"C1": {ID: "C1", ConversationType: "channel", DisplayName: "#general", LatestMsgTs: ago(time.Hour), TeamID: "T1"},
+		// in channels, only threads we started or replied in
+		threadID("C1", ago(4*time.Hour)): {
+			ID: threadID("C1", ago(4*time.Hour)), ConversationType: "thread", DisplayName: "#general › replied in",
+			LatestMsgTs: ago(3 * time.Hour), TeamID: "T1", ChannelID: "C1", ThreadTs: ago(4 * time.Hour),
+		},
+		threadID("C1", ago(5*time.Hour)): {
+			ID: threadID("C1", ago(5*time.Hour)), ConversationType: "thread", DisplayName: "#general › started",
+			LatestMsgTs: ago(4 * time.Hour), TeamID: "T1", ChannelID: "C1", ThreadTs: ago(5 * time.Hour),
+		},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected %v, got %v", expected, found)
+	}
+
+	// one message tells us what's newest, so only conversations with
+	// messages since the lookback are scanned for more
+	scans, latests := make(map[string]int), make(map[string]int)
+	for _, call := range s.callsTo("conversations.history") {
+		if call.Get("limit") != "1" {
+			scans[call.Get("channel")]++
+		} else {
+			latests[call.Get("channel")]++
+		}
+	}
+	if !reflect.DeepEqual(scans, map[string]int{"D1": 1, "C1": 1}) {
+		t.Errorf("Expected D1 and C1 scanned once each, got %v", scans)
+	}
+	if !reflect.DeepEqual(latests, map[string]int{"D1": 1, "D2": 1}) {
+		t.Errorf("Expected only D1 and D2 asked for their newest message, got %v", latests)
+	}
+	if len(s.callsTo("conversations.replies")) != 0 {
+		t.Errorf("Expected latest replies found without fetching threads, got %d calls", len(s.callsTo("conversations.replies")))
+	}
+}
+
+func TestFetchConversationsWithoutThreadsScansNothing(t *testing.T) {
+	s := newFakeSlackServer(t)
+	defer s.Close()
+
+	recent := timeToSlackTs(time.Now())
+	handleIMs(s, map[string][]interface{}{
+		"D1": {threadedMessage("U1", "lunch?", recent, recent, "UME")},
+	})
+
+	api := s.api(APIOptions{ThreadLookback: 24 * time.Hour})
+	conversations, err := api.FetchConversations(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatalf("FetchConversations failed with error %s", err)
+	}
+	if len(conversations) != 2 {
+		t.Errorf("Expected just the 2 IMs, got %v", conversations)
+	}
+	for _, call := range s.callsTo("conversations.history") {
+		if call.Get("limit") != "1" {
+			t.Errorf("Expected only the newest message asked for, got limit %s", call.Get("limit"))
+		}
+	}
+}
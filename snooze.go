@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+// The hour a snooze until a day, with no time given, ends.
+const defaultSnoozeHour = 9
+
+var snoozeDays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var clockPattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+var daysPattern = regexp.MustCompile(`^(\d+)d$`)
+
+// Parse a time of day like 9am, 5:30pm or 17:00 into hours and minutes.
+func parseClock(clock string) (int, int, error) {
+	match := clockPattern.FindStringSubmatch(clock)
+	if match == nil {
+		return 0, 0, fmt.Errorf("Can't understand the time %s", clock)
+	}
+
+	hour, _ := strconv.Atoi(match[1])
+	minute := 0
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+
+	switch {
+	case match[3] != "" && (hour < 1 || hour > 12):
+		return 0, 0, fmt.Errorf("Can't understand the time %s", clock)
+	case match[3] == "am" && hour == 12:
+		hour = 0
+	case match[3] == "pm" && hour < 12:
+		hour += 12
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("Can't understand the time %s", clock)
+	}
+
+	return hour, minute, nil
+}
+
+// Work out when a snooze given as e.g. "1h", "2d", "tomorrow 9am", "monday",
+// "5pm" or "new" should end.  Days without a time end at defaultSnoozeHour,
+// and a weekday always means the next one after today.  The zero time means
+// the snooze lasts until a new message arrives.
+func parseSnooze(input string, now time.Time) (time.Time, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+
+	switch input {
+	case "":
+		return time.Time{}, errors.New("Snooze until when?")
+	case "new", "message", "new message":
+		return time.Time{}, nil
+	}
+
+	if match := daysPattern.FindStringSubmatch(input); match != nil {
+		days, _ := strconv.Atoi(match[1])
+		input = fmt.Sprintf("%dh", days*24)
+	}
+
+	if d, err := time.ParseDuration(input); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("Can't snooze for %s", input)
+		}
+		return now.Add(d), nil
+	}
+
+	words := strings.Fields(input)
+	day := now
+	dayGiven := true
+	switch weekday, isWeekday := snoozeDays[words[0]]; {
+	case words[0] == "today":
+	case words[0] == "tomorrow":
+		day = now.AddDate(0, 0, 1)
+	case isWeekday:
+		ahead := (int(weekday)-int(now.Weekday())+6)%7 + 1
+		day = now.AddDate(0, 0, ahead)
+	default:
+		dayGiven = false
+	}
+
+	if dayGiven {
+		words = words[1:]
+	}
+
+	hour, minute := defaultSnoozeHour, 0
+	if len(words) > 0 {
+		var err error
+		hour, minute, err = parseClock(strings.Join(words, ""))
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	until := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+
+	if !until.After(now) {
+		if dayGiven {
+			return time.Time{}, fmt.Errorf("%s has already passed", input)
+		}
+		// a time on its own means the next time it comes around
+		until = until.AddDate(0, 0, 1)
+	}
+
+	return until, nil
+}
+
+func describeSnooze(until time.Time) string {
+	if until.IsZero() {
+		return "until a new message"
+	}
+	return "until " + until.Format("Mon Jan 2 15:04")
+}
+
+// Open an input line under the inbox for snoozing the selected conversation.
+// Enter snoozes it, Escape abandons the snooze.
+func (ib *inbox) showSnooze() {
+	ac, ok := ib.selectedConversation()
+	if !ok {
+		return
+	}
+
+	input := tview.NewInputField()
+	input.SetLabel(tview.Escape(fmt.Sprintf("Snooze %s until (1h, tomorrow 9am, monday, new): ", ac.DisplayName)))
+
+	input.SetDoneFunc(func(key tcell.Key) {
+		ib.app.SetRoot(ib.root, true)
+		if key != tcell.KeyEnter {
+			return
+		}
+
+		until, err := parseSnooze(input.GetText(), time.Now())
+		if err == nil {
+			err = ib.db.Do(JournalEntry{
+				Action:         snoozeAction,
+				TeamID:         ac.TeamID,
+				ConversationID: ac.ID,
+				Ts:             ac.LatestMsgTs,
+				SnoozedUntil:   until,
+				Position:       ib.list.GetCurrentItem(),
+			})
+		}
+		if err != nil {
+			ib.showModal(fmt.Sprintf("%s", err))
+			return
+		}
+
+		ib.reloadList()
+		ib.reportProgress(fmt.Sprintf("Snoozed %s %s", ac.DisplayName, describeSnooze(until)))
+	})
+
+	snoozing := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ib.root, 0, 1, false).
+		AddItem(input, 1, 0, true)
+	ib.app.SetRoot(snoozing, true)
+}
+
+// Show the snoozed conversations in place of the inbox.  The unack key wakes
+// the selected one.
+func (ib *inbox) showSnoozed() {
+	var snoozed []SnoozedConversation
+
+	ib.showSubview(
+		fmt.Sprintf("Snoozed (%s wakes, Esc returns)", ib.display.keys.names(unackKey)),
+		func(list *tview.List) error {
+			var err error
+			snoozed, err = ib.db.GetSnoozedConversations()
+			if err != nil {
+				return err
+			}
+
+			for _, sc := range snoozed {
+				list.AddItem(fmt.Sprintf("%s [%s]%s[-]", tview.Escape(sc.DisplayName), tagColor(ib.display.theme.Muted), describeSnooze(sc.SnoozedUntil)), "", 0, nil)
+			}
+			return nil
+		},
+		func(action string, i int) error {
+			if action != unackKey {
+				return nil
+			}
+			return ib.db.Do(JournalEntry{Action: unsnoozeAction, TeamID: snoozed[i].TeamID, ConversationID: snoozed[i].ID, Position: noPosition})
+		})
+}
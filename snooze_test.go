@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSnooze(t *testing.T) {
+	// a wednesday
+	now := time.Date(2020, time.June, 10, 14, 30, 0, 0, time.UTC)
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2020, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"1h", now.Add(time.Hour)},
+		{" 90M ", now.Add(90 * time.Minute)},
+		{"2d", now.Add(48 * time.Hour)},
+		{"new", time.Time{}},
+		{"tomorrow", at(11, 9, 0)},
+		{"tomorrow 9am", at(11, 9, 0)},
+		{"Tomorrow 5:15 pm", at(11, 17, 15)},
+		{"today 17:00", at(10, 17, 0)},
+		{"monday", at(15, 9, 0)},
+		{"thu 12am", at(11, 0, 0)},
+		{"wednesday", at(17, 9, 0)},
+		{"5pm", at(10, 17, 0)},
+		{"9am", at(11, 9, 0)},
+		{"12pm", at(11, 12, 0)},
+	}
+
+	for _, c := range cases {
+		actual, err := parseSnooze(c.input, now)
+		if err != nil {
+			t.Errorf("For %q got error %s", c.input, err)
+			continue
+		}
+		if !actual.Equal(c.expected) {
+			t.Errorf("For %q expected %s, got %s", c.input, c.expected, actual)
+		}
+	}
+
+	for _, input := range []string{"", "-1h", "later", "today 9am", "13pm", "25:00", "monday noonish"} {
+		_, err := parseSnooze(input, now)
+		if err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell"
+	"github.com/rivo/tview"
+)
+
+// Show a list in place of the inbox, filled by load, until Escape or the quit
+// key goes back to the inbox.  The action of any other bound key pressed on a
+// row is passed to onAction with the row's index, after which the list is
+// loaded again.
+func (ib *inbox) showSubview(title string, load func(*tview.List) error, onAction func(string, int) error) {
+	list := tview.NewList()
+	list.ShowSecondaryText(false)
+	list.SetBorder(true)
+	list.SetTitle(title)
+
+	back := func() {
+		ib.reloadList()
+		ib.app.SetRoot(ib.root, true)
+	}
+
+	// errors are shown over the subview rather than the inbox
+	showError := func(err error) {
+		modal := tview.NewModal()
+		modal.SetText(fmt.Sprintf("%s", err))
+		modal.AddButtons([]string{"OK"})
+		modal.SetDoneFunc(func(int, string) {
+			ib.app.SetRoot(list, true)
+		})
+		ib.app.SetRoot(modal, false)
+	}
+
+	reload := func() {
+		selected := list.GetCurrentItem()
+		list.Clear()
+
+		err := load(list)
+		if err != nil {
+			showError(err)
+			return
+		}
+
+		if selected >= list.GetItemCount() {
+			selected = list.GetItemCount() - 1
+		}
+		if selected >= 0 {
+			list.SetCurrentItem(selected)
+		}
+	}
+
+	list.SetDoneFunc(back)
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() != tcell.KeyRune {
+			return event
+		}
+
+		ch := event.Rune()
+		action, found := ib.display.actions[ch]
+		if !found {
+			return event
+		}
+
+		switch action {
+		case downKey:
+			return tcell.NewEventKey(tcell.KeyDown, ch, event.Modifiers())
+		case upKey:
+			return tcell.NewEventKey(tcell.KeyUp, ch, event.Modifiers())
+		case quitKey:
+			back()
+			return nil
+		default:
+			i := list.GetCurrentItem()
+			if i < 0 || i >= list.GetItemCount() {
+				return nil
+			}
+
+			err := onAction(action, i)
+			if err != nil {
+				showError(err)
+				return nil
+			}
+			reload()
+			return nil
+		}
+	})
+
+	ib.app.SetRoot(list, true)
+	reload()
+}
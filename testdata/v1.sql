@@ -0,0 +1,35 @@
+-- A db as created by slackbox at schema version 1, before migrations existed.
+
+create table version (
+  singleton int not null primary key,
+  version int not null
+);
+
+insert into version (singleton, version) values (1, 1);
+
+create table conversations (
+  id text not null primary key,
+  conversation_type text not null,
+  display_name text not null,
+  latest_msg_ts text
+);
+
+create table acknowledgements (
+  conversation_id text not null,
+  acknowledged_through_ts text not null,
+  acknowledged_at int
+);
+
+create unique index ack_convo_idx on acknowledgements (
+  conversation_id, acknowledged_through_ts);
+
+insert into conversations (id, conversation_type, display_name, latest_msg_ts)
+values
+  ('D1', 'im', 'Alice', '2.0'),
+  ('D2', 'im', 'Bob', '3.0');
+
+insert into acknowledgements (conversation_id, acknowledged_through_ts)
+values
+  ('D1', '1.0'),
+  ('D1', '2.0'),
+  ('D2', '1.0');
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+// How many of a conversation's messages since the thread lookback are checked
+// for threads to follow on each refresh, newest first.
+const historyScanLimit = 200
+
+// How many replies to ask slack for in each page of a thread.
+const repliesPageSize = 200
+
+// How much of a thread's first message is used to name it.
+const threadNameLength = 40
+
+// Threads are tracked as conversations of their own, under an id made from
+// the channel and the ts of the thread's first message.
+func threadID(channelID string, threadTs string) string {
+	return channelID + "/" + threadTs
+}
+
+func newThread(parent Conversation, threadTs string) Conversation {
+	channelID := parent.SlackChannelID()
+	return Conversation{
+		ID:               threadID(channelID, threadTs),
+		ConversationType: "thread",
+		TeamID:           parent.TeamID,
+		ChannelID:        channelID,
+		ThreadTs:         threadTs,
+	}
+}
+
+var entityPattern = regexp.MustCompile("<([^>\\n]+)>")
+
+// Reduce message text to something short and plain enough to name a thread.
+func summarizeText(text string, userName func(string) string) string {
+	text = entityPattern.ReplaceAllStringFunc(text, func(entity string) string {
+		entity = strings.Trim(entity, "<>")
+		target := entity
+		label := ""
+		if i := strings.Index(entity, "|"); i >= 0 {
+			target = entity[:i]
+			label = entity[i+1:]
+		}
+
+		switch {
+		case label != "":
+			return label
+		case strings.HasPrefix(target, "@"):
+			return "@" + userName(target[1:])
+		default:
+			return strings.TrimLeft(target, "#!")
+		}
+	})
+
+	text = strings.Join(strings.Fields(slackUnescaper.Replace(text)), " ")
+
+	if utf8.RuneCountInString(text) > threadNameLength {
+		text = string([]rune(text)[:threadNameLength]) + "…"
+	}
+
+	return text
+}
+
+func threadName(parentName string, parentText string, userName func(string) string) string {
+	summary := summarizeText(parentText, userName)
+	if summary == "" {
+		summary = "thread"
+	}
+	return parentName + " › " + summary
+}
+
+// A message from a conversation's history, with what slack says of the thread
+// off it.  slack-go doesn't decode latest_reply or reply_users, which tell us
+// a thread has news, and whether we're in it, without fetching its replies.
+type threadParent struct {
+	Ts          string   `json:"ts"`
+	User        string   `json:"user"`
+	Text        string   `json:"text"`
+	ReplyCount  int      `json:"reply_count"`
+	ReplyUsers  []string `json:"reply_users"`
+	LatestReply string   `json:"latest_reply"`
+}
+
+// Every thread in a DM is worth following, but in a channel only the ones we
+// started or replied in are.  Slack only lists the first few people to reply,
+// so threads we join late are picked up as we reply from slackbox.
+func (api *SlackBoxAPI) followsThread(parent Conversation, msg threadParent) bool {
+	if msg.ReplyCount == 0 {
+		return false
+	}
+
+	if parent.ConversationType != "channel" || msg.User == api.userID {
+		return true
+	}
+
+	for _, user := range msg.ReplyUsers {
+		if user == api.userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fetch the newest messages in the conversation since oldest, newest first,
+// calling conversations.history by hand to see their threads.
+func (api *SlackBoxAPI) fetchThreadParents(ctx context.Context, channelID string, oldest string) ([]threadParent, error) {
+	form := url.Values{
+		"channel": {channelID},
+		"oldest":  {oldest},
+		"limit":   {strconv.Itoa(historyScanLimit)},
+	}
+
+	var history struct {
+		OK       bool           `json:"ok"`
+		Error    string         `json:"error"`
+		Messages []threadParent `json:"messages"`
+	}
+	err := api.limiter.call(ctx, "conversations.history", func() error {
+		return api.postForm(ctx, "conversations.history", form, &history)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !history.OK {
+		return nil, errors.New(history.Error)
+	}
+
+	return history.Messages, nil
+}
+
+// Call the method as slack-go would, decoding the reply into v, and failing
+// with errors the rate limiter understands.
+func (api *SlackBoxAPI) postForm(ctx context.Context, method string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", api.apiURL+method, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+api.token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil {
+			retryAfter = 1
+		}
+		return &slack.RateLimitedError{RetryAfter: time.Duration(retryAfter) * time.Second}
+	case resp.StatusCode != http.StatusOK:
+		return httpStatusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// An error status from slack, which isTransient can tell is worth retrying,
+// as it can slack-go's own.
+type httpStatusError struct {
+	code   int
+	status string
+}
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("slack server error: %s", e.status)
+}
+
+func (e httpStatusError) HTTPStatusCode() int {
+	return e.code
+}
+
+// The conversation's latest message ts, and if we follow threads and it's
+// had a message since the thread lookback, its messages since then to find
+// threads off.  Replies don't show in a conversation's own history, so
+// rather than only looking at its new messages, threads are found from the
+// latest reply to each message since the lookback.
+//
+// A conversation already known to have had a message since the lookback
+// has every message newer than that in the scan, so its latest ts is taken
+// from the scan rather than costing a call of its own.
+func (api *SlackBoxAPI) fetchLatestAndThreadParents(ctx context.Context, channelID string, knownLatestMsgTs string) (string, []threadParent, error) {
+	since := timeToSlackTs(time.Now().Add(-api.threadLookback))
+
+	latestMsgTs := knownLatestMsgTs
+	if !api.threads || knownLatestMsgTs < since {
+		var err error
+		latestMsgTs, err = api.fetchLatestMsgTs(ctx, channelID, knownLatestMsgTs)
+		if err != nil || !api.threads || latestMsgTs < since {
+			return latestMsgTs, nil, err
+		}
+	}
+
+	parents, err := api.fetchThreadParents(ctx, channelID, since)
+	if err != nil {
+		return latestMsgTs, nil, err
+	}
+
+	for _, msg := range parents {
+		if msg.Ts > latestMsgTs {
+			latestMsgTs = msg.Ts
+		}
+	}
+
+	return latestMsgTs, parents, nil
+}
+
+// Find the threads off the parent's messages, from
+// fetchLatestAndThreadParents, that we should follow but don't yet.
+func (api *SlackBoxAPI) newThreads(ctx context.Context, parent Conversation, messages []threadParent, latestMsgTimestamps map[string]string) ([]Conversation, error) {
+	threads := make([]Conversation, 0)
+	var err error
+
+	for _, msg := range messages {
+		if !api.followsThread(parent, msg) {
+			continue
+		}
+
+		thread := newThread(parent, msg.Ts)
+
+		// threads we already follow are refreshed by FetchThreads
+		if _, found := latestMsgTimestamps[thread.ID]; found {
+			continue
+		}
+
+		thread.DisplayName = threadName(parent.DisplayName, msg.Text, api.UserName)
+		thread.LatestMsgTs = msg.LatestReply
+
+		if thread.LatestMsgTs == "" {
+			thread, err = api.FetchThread(ctx, thread, parent.DisplayName)
+			if err != nil {
+				return threads, err
+			}
+		}
+
+		threads = append(threads, thread)
+	}
+
+	return threads, nil
+}
+
+// Fetch the replies in the thread, oldest first, after oldest (or all of
+// them, including the first message, if oldest is blank).  With a limit,
+// only the newest limit of them are kept, though slack pages replies oldest
+// first so every page is still read.
+func (api *SlackBoxAPI) fetchReplies(ctx context.Context, channelID string, threadTs string, oldest string, limit int) ([]slack.Message, error) {
+	replies := make([]slack.Message, 0)
+	params := &slack.GetConversationRepliesParameters{ChannelID: channelID, Timestamp: threadTs, Oldest: oldest, Limit: repliesPageSize}
+
+	for {
+		var msgs []slack.Message
+		var hasMore bool
+		var nextCursor string
+		err := api.limiter.call(ctx, "conversations.replies", func() (err error) {
+			msgs, hasMore, nextCursor, err = api.client.GetConversationRepliesContext(ctx, params)
+			return err
+		})
+
+		if err != nil {
+			return replies, err
+		}
+
+		for _, msg := range msgs {
+			// slack may include the first message even when it's older than
+			// oldest
+			if oldest == "" || msg.Timestamp > oldest {
+				replies = append(replies, msg)
+			}
+		}
+
+		if limit > 0 && len(replies) > limit {
+			replies = replies[len(replies)-limit:]
+		}
+
+		if !hasMore || nextCursor == "" {
+			break
+		}
+
+		params.Cursor = nextCursor
+	}
+
+	return replies, nil
+}
+
+// Bring the thread's latest ts up to date with any replies since.  A thread
+// without a DisplayName is named from its first message, as part of the
+// parent conversation named parentName.
+func (api *SlackBoxAPI) FetchThread(ctx context.Context, thread Conversation, parentName string) (Conversation, error) {
+	oldest := thread.LatestMsgTs
+	if thread.DisplayName == "" {
+		oldest = ""
+	}
+
+	replies, err := api.fetchReplies(ctx, thread.ChannelID, thread.ThreadTs, oldest, 0)
+	if err != nil {
+		return thread, err
+	}
+
+	for _, msg := range replies {
+		if msg.Timestamp == thread.ThreadTs {
+			if thread.DisplayName == "" {
+				thread.DisplayName = threadName(parentName, msg.Text, api.UserName)
+			}
+			continue
+		}
+
+		if msg.Timestamp > thread.LatestMsgTs {
+			thread.LatestMsgTs = msg.Timestamp
+		}
+	}
+
+	if thread.DisplayName == "" {
+		thread.DisplayName = threadName(parentName, "", api.UserName)
+	}
+
+	return thread, nil
+}
+
+// Refresh the threads we follow, in the same order, or none of them if we
+// aren't following threads.
+func (api *SlackBoxAPI) FetchThreads(ctx context.Context, threads []Conversation) ([]Conversation, error) {
+	if !api.threads {
+		return make([]Conversation, 0), nil
+	}
+
+	fetched := make([]Conversation, len(threads))
+	progress := api.newProgressCounter("threads", len(threads))
+
+	err := api.forEach(ctx, len(threads), func(ctx context.Context, i int) (err error) {
+		fetched[i], err = api.FetchThread(ctx, threads[i], "")
+		progress()
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fetched, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestThreadName(t *testing.T) {
+	userName := func(id string) string {
+		return "name-of-" + id
+	}
+
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"", "#general › thread"},
+		{"  lunch?\n\nanyone ", "#general › lunch? anyone"},
+		{"ask <@U123> about <https://example.com|the doc> &amp; <#C1|random>", "#general › ask @name-of-U123 about the doc & random"},
+		{"<!here> this message goes on for quite a bit longer than forty", "#general › here this message goes on for quite a bi…"},
+	}
+
+	for _, c := range cases {
+		actual := threadName("#general", c.text, userName)
+		if actual != c.expected {
+			t.Errorf("For %q expected %q, got %q", c.text, c.expected, actual)
+		}
+	}
+}
+
+func TestIsThreadReply(t *testing.T) {
+	ev := &slack.MessageEvent{}
+	ev.Timestamp = "2.0"
+
+	if isThreadReply(ev) {
+		t.Error("Message outside a thread treated as a reply")
+	}
+
+	ev.ThreadTimestamp = "2.0"
+	if isThreadReply(ev) {
+		t.Error("First message of a thread treated as a reply")
+	}
+
+	ev.ThreadTimestamp = "1.0"
+	if !isThreadReply(ev) {
+		t.Error("Reply not treated as a reply")
+	}
+
+	ev.SubType = "thread_broadcast"
+	if isThreadReply(ev) {
+		t.Error("Reply sent to the channel treated as only a reply")
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// How token sources are written, for flags and the config file.
+const tokenSourceUsage = "a file path, env:VAR, cmd:command, keyring:attribute=value... or encrypted:path"
+
+// Somewhere a slack token is kept.
+type tokenProvider interface {
+	Token() (string, error)
+}
+
+// Reads secrets like passphrases, showing prompt.
+type secretReader func(prompt string) ([]byte, error)
+
+// Parse a token source like env:SLACK_TOKEN or cmd:pass show slack into the
+// provider it names.  Anything without a known prefix is a plain file.
+func parseTokenSource(source string, readSecret secretReader) (tokenProvider, error) {
+	kind, rest := "file", source
+	if i := strings.Index(source, ":"); i >= 0 {
+		switch source[:i] {
+		case "file", "env", "cmd", "keyring", "encrypted":
+			kind, rest = source[:i], source[i+1:]
+		}
+	}
+
+	if strings.TrimSpace(rest) == "" {
+		return nil, fmt.Errorf("Token source %s is missing what to read", source)
+	}
+
+	switch kind {
+	case "env":
+		return envProvider{name: rest}, nil
+	case "cmd":
+		return commandProvider{command: rest}, nil
+	case "keyring":
+		attributes, err := parseAttributes(rest)
+		if err != nil {
+			return nil, err
+		}
+		return keyringProvider{attributes: attributes, connect: connectSecretService}, nil
+	case "encrypted":
+		return encryptedFileProvider{path: expandHome(rest), readSecret: readSecret}, nil
+	default:
+		return fileProvider{path: expandHome(rest)}, nil
+	}
+}
+
+// A token source that can be written to as well as read.
+type tokenStore interface {
+	tokenProvider
+	Store(token string) error
+}
+
+// Write the token to its source, replacing any there, e.g. after logging in.
+func storeToken(source string, token string, readSecret secretReader) error {
+	provider, err := parseTokenSource(source, readSecret)
+	if err != nil {
+		return err
+	}
+
+	store, ok := provider.(tokenStore)
+	if !ok {
+		return fmt.Errorf("Can't store a token in %s, only in a file path or encrypted:path", source)
+	}
+
+	return store.Store(token)
+}
+
+// Read the token from its source, prompting for any passphrase on the
+// terminal.
+func readToken(source string) (string, error) {
+	provider, err := parseTokenSource(source, readTerminalSecret)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := provider.Token()
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("Token source %s gave an empty token", source)
+	}
+
+	return token, nil
+}
+
+// A plaintext file only we can read.
+type fileProvider struct {
+	path string
+}
+
+// Read a file, refusing one anyone else can get at.
+func readPrivateFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error stating tokenpath %s %s", path, err)
+	}
+
+	if (info.Mode().Perm() & 0077) != 0 {
+		return nil, fmt.Errorf("Tokenpath %s is accessible to group or world with perms %s", path, info.Mode().Perm())
+	}
+
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading tokenpath %s %s", path, err)
+	}
+
+	return dat, nil
+}
+
+func (p fileProvider) Token() (string, error) {
+	dat, err := readPrivateFile(p.path)
+	return strings.TrimSpace(string(dat)), err
+}
+
+// Write a file only we can read, replacing any already there.
+func writePrivateFile(path string, dat []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Error writing tokenpath %s %s", path, err)
+	}
+
+	// a file that was already there keeps its perms, so tighten them before
+	// writing the token
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.Write(dat)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing tokenpath %s %s", path, err)
+	}
+
+	return nil
+}
+
+func (p fileProvider) Store(token string) error {
+	return writePrivateFile(p.path, []byte(token+"\n"))
+}
+
+// An environment variable.
+type envProvider struct {
+	name string
+}
+
+func (p envProvider) Token() (string, error) {
+	token, found := os.LookupEnv(p.name)
+	if !found {
+		return "", fmt.Errorf("No token in $%s, as it isn't set", p.name)
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// A shell command printing the token as the first line of its output, as
+// password managers like pass do.
+type commandProvider struct {
+	command string
+}
+
+func (p commandProvider) Token() (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", p.command)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Error running %s for a token: %s %s", p.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	line := strings.SplitN(string(out), "\n", 2)[0]
+	return strings.TrimSpace(line), nil
+}
+
+// A file encrypted with a passphrase, as written by slackbox token encrypt.
+type encryptedFileProvider struct {
+	path       string
+	readSecret secretReader
+}
+
+func (p encryptedFileProvider) Token() (string, error) {
+	dat, err := readPrivateFile(p.path)
+	if err != nil {
+		return "", err
+	}
+
+	passphrase, err := p.readSecret(fmt.Sprintf("Passphrase for %s: ", p.path))
+	if err != nil {
+		return "", err
+	}
+
+	token, err := decryptToken(dat, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("Error decrypting %s: %s", p.path, err)
+	}
+	return token, nil
+}
+
+func (p encryptedFileProvider) Store(token string) error {
+	passphrase, err := readNewPassphrase(p.readSecret)
+	if err != nil {
+		return err
+	}
+
+	dat, err := encryptToken(token, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return writePrivateFile(p.path, dat)
+}
+
+// Ask for a new passphrase twice, to be sure it's the one meant.
+func readNewPassphrase(readSecret secretReader) ([]byte, error) {
+	passphrase, err := readSecret("Passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	again, err := readSecret("Passphrase again: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("The passphrase can't be empty")
+	}
+	if !bytes.Equal(passphrase, again) {
+		return nil, errors.New("The passphrases don't match")
+	}
+
+	return passphrase, nil
+}
+
+// The first line of an encrypted token file, so it's recognizable.
+const encryptedTokenHeader = "slackbox encrypted token v1"
+
+const (
+	saltLength  = 16
+	nonceLength = 24
+	keyLength   = 32
+)
+
+// The key for the passphrase and salt, slow enough to make guessing the
+// passphrase expensive.
+func deriveKey(passphrase []byte, salt []byte) (*[keyLength]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, keyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var key [keyLength]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// Seal the token with the passphrase, as a header line followed by the
+// base64 of the salt, the nonce and the sealed token.
+func encryptToken(token string, passphrase []byte) ([]byte, error) {
+	var salt [saltLength]byte
+	var nonce [nonceLength]byte
+	_, err := io.ReadFull(rand.Reader, salt[:])
+	if err == nil {
+		_, err = io.ReadFull(rand.Reader, nonce[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(passphrase, salt[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append(salt[:], nonce[:]...)
+	sealed = secretbox.Seal(sealed, []byte(token), &nonce, key)
+
+	return []byte(fmt.Sprintf("%s\n%s\n", encryptedTokenHeader, base64.StdEncoding.EncodeToString(sealed))), nil
+}
+
+func decryptToken(dat []byte, passphrase []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(dat)), "\n")
+	if len(lines) != 2 || lines[0] != encryptedTokenHeader {
+		return "", errors.New("Not an encrypted token file")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sealed) < saltLength+nonceLength+secretbox.Overhead {
+		return "", errors.New("Encrypted token file is corrupt")
+	}
+
+	var nonce [nonceLength]byte
+	copy(nonce[:], sealed[saltLength:saltLength+nonceLength])
+
+	key, err := deriveKey(passphrase, sealed[:saltLength])
+	if err != nil {
+		return "", err
+	}
+
+	token, ok := secretbox.Open(nil, sealed[saltLength+nonceLength:], &nonce, key)
+	if !ok {
+		return "", errors.New("Wrong passphrase")
+	}
+	return string(token), nil
+}
+
+// Reads stdin when it isn't a terminal, shared so that lines aren't lost to
+// buffering between secrets.
+var stdinLines = bufio.NewReader(os.Stdin)
+
+// Read a secret from the terminal without echoing it, or if stdin isn't a
+// terminal, a line from it.
+func readTerminalSecret(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := stdinLines.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, fmt.Errorf("Error reading %s: %s", strings.TrimSuffix(strings.TrimSpace(prompt), ":"), err)
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return secret, err
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTokenFile(t *testing.T, dir string, name string, contents string, perm os.FileMode) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(contents), perm)
+	if err != nil {
+		t.Fatalf("Could not write %s %s", path, err)
+	}
+	// WriteFile's perms are subject to the umask
+	err = os.Chmod(path, perm)
+	if err != nil {
+		t.Fatalf("Could not chmod %s %s", path, err)
+	}
+	return path
+}
+
+// Reads the secrets given in order, failing once they run out.
+func fakeSecrets(t *testing.T, secrets ...string) secretReader {
+	return func(prompt string) ([]byte, error) {
+		if len(secrets) == 0 {
+			t.Fatalf("Unexpected prompt %s", prompt)
+		}
+		secret := secrets[0]
+		secrets = secrets[1:]
+		return []byte(secret), nil
+	}
+}
+
+func TestParseTokenSource(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected tokenProvider
+	}{
+		{"tokenfile.txt", fileProvider{path: "tokenfile.txt"}},
+		{"file:/tmp/token", fileProvider{path: "/tmp/token"}},
+		{"/tmp/odd:name", fileProvider{path: "/tmp/odd:name"}},
+		{"env:SLACK_TOKEN", envProvider{name: "SLACK_TOKEN"}},
+		{"cmd:pass show slack", commandProvider{command: "pass show slack"}},
+	}
+
+	for _, c := range cases {
+		provider, err := parseTokenSource(c.source, nil)
+		if err != nil {
+			t.Errorf("Parsing %s failed with error %s", c.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.expected, provider) {
+			t.Errorf("Expected %s to parse to %v, got %v", c.source, c.expected, provider)
+		}
+	}
+
+	provider, err := parseTokenSource("keyring:service=slack account=work", nil)
+	if err != nil {
+		t.Fatalf("Parsing a keyring source failed with error %s", err)
+	}
+	expected := map[string]string{"service": "slack", "account": "work"}
+	if kp, ok := provider.(keyringProvider); !ok || !reflect.DeepEqual(expected, kp.attributes) {
+		t.Errorf("Expected a keyring provider with %v, got %v", expected, provider)
+	}
+
+	provider, err = parseTokenSource("encrypted:/tmp/token.enc", nil)
+	if err != nil {
+		t.Fatalf("Parsing an encrypted source failed with error %s", err)
+	}
+	if ep, ok := provider.(encryptedFileProvider); !ok || ep.path != "/tmp/token.enc" {
+		t.Errorf("Expected an encrypted file provider for /tmp/token.enc, got %v", provider)
+	}
+
+	for _, source := range []string{"env:", "cmd: ", "keyring:service", "keyring:=slack"} {
+		_, err = parseTokenSource(source, nil)
+		if err == nil {
+			t.Errorf("Expected an error parsing %s", source)
+		}
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackbox-tokens")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTokenFile(t, dir, "token", "xoxp-1234\n", 0600)
+	token, err := fileProvider{path: path}.Token()
+	if err != nil {
+		t.Fatalf("Reading the token failed with error %s", err)
+	}
+	if token != "xoxp-1234" {
+		t.Errorf("Expected token xoxp-1234, got %q", token)
+	}
+
+	path = writeTokenFile(t, dir, "shared", "xoxp-1234\n", 0644)
+	_, err = fileProvider{path: path}.Token()
+	if err == nil || !strings.Contains(err.Error(), "accessible to group or world") {
+		t.Errorf("Expected an error reading a world readable token, got %v", err)
+	}
+
+	_, err = fileProvider{path: filepath.Join(dir, "missing")}.Token()
+	if err == nil {
+		t.Errorf("Expected an error reading a missing token")
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	os.Setenv("SLACKBOX_TEST_TOKEN", " xoxp-1234\n")
+	defer os.Unsetenv("SLACKBOX_TEST_TOKEN")
+
+	token, err := envProvider{name: "SLACKBOX_TEST_TOKEN"}.Token()
+	if err != nil {
+		t.Fatalf("Reading the token failed with error %s", err)
+	}
+	if token != "xoxp-1234" {
+		t.Errorf("Expected token xoxp-1234, got %q", token)
+	}
+
+	_, err = envProvider{name: "SLACKBOX_TEST_UNSET"}.Token()
+	if err == nil {
+		t.Errorf("Expected an error reading an unset variable")
+	}
+}
+
+func TestCommandProvider(t *testing.T) {
+	// like pass, the token is the first line
+	token, err := commandProvider{command: "printf 'xoxp-1234\\nlogin: me\\n'"}.Token()
+	if err != nil {
+		t.Fatalf("Running the command failed with error %s", err)
+	}
+	if token != "xoxp-1234" {
+		t.Errorf("Expected token xoxp-1234, got %q", token)
+	}
+
+	_, err = commandProvider{command: "echo no such entry >&2; exit 1"}.Token()
+	if err == nil || !strings.Contains(err.Error(), "no such entry") {
+		t.Errorf("Expected an error with the command's stderr, got %v", err)
+	}
+}
+
+func TestEncryptedFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackbox-tokens")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dat, err := encryptToken("xoxp-1234", []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Encrypting failed with error %s", err)
+	}
+	if strings.Contains(string(dat), "xoxp-1234") {
+		t.Errorf("Expected the token encrypted, got %s", dat)
+	}
+	path := writeTokenFile(t, dir, "token.enc", string(dat), 0600)
+
+	token, err := encryptedFileProvider{path: path, readSecret: fakeSecrets(t, "hunter2")}.Token()
+	if err != nil {
+		t.Fatalf("Decrypting failed with error %s", err)
+	}
+	if token != "xoxp-1234" {
+		t.Errorf("Expected token xoxp-1234, got %q", token)
+	}
+
+	_, err = encryptedFileProvider{path: path, readSecret: fakeSecrets(t, "hunter3")}.Token()
+	if err == nil || !strings.Contains(err.Error(), "Wrong passphrase") {
+		t.Errorf("Expected a wrong passphrase error, got %v", err)
+	}
+
+	// the permission check still applies
+	path = writeTokenFile(t, dir, "shared.enc", string(dat), 0640)
+	_, err = encryptedFileProvider{path: path, readSecret: fakeSecrets(t)}.Token()
+	if err == nil || !strings.Contains(err.Error(), "accessible to group or world") {
+		t.Errorf("Expected an error reading a group readable token, got %v", err)
+	}
+
+	path = writeTokenFile(t, dir, "plain", "xoxp-1234\n", 0600)
+	_, err = encryptedFileProvider{path: path, readSecret: fakeSecrets(t, "hunter2")}.Token()
+	if err == nil || !strings.Contains(err.Error(), "Not an encrypted token file") {
+		t.Errorf("Expected an error decrypting a plain token, got %v", err)
+	}
+}
+
+func TestStoreToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackbox-tokens")
+	if err != nil {
+		t.Fatalf("Could not make temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// replacing a token also tightens the file's perms
+	path := writeTokenFile(t, dir, "token", "xoxp-old\n", 0644)
+	err = storeToken(path, "xoxp-new", nil)
+	if err != nil {
+		t.Fatalf("Storing the token failed with error %s", err)
+	}
+	token, err := readToken(path)
+	if err != nil || token != "xoxp-new" {
+		t.Errorf("Expected token xoxp-new, got %q %v", token, err)
+	}
+
+	path = filepath.Join(dir, "token.enc")
+	err = storeToken("encrypted:"+path, "xoxp-new", fakeSecrets(t, "hunter2", "hunter2"))
+	if err != nil {
+		t.Fatalf("Storing the encrypted token failed with error %s", err)
+	}
+	token, err = encryptedFileProvider{path: path, readSecret: fakeSecrets(t, "hunter2")}.Token()
+	if err != nil || token != "xoxp-new" {
+		t.Errorf("Expected token xoxp-new, got %q %v", token, err)
+	}
+
+	err = storeToken("cmd:pass show slack", "xoxp-new", nil)
+	if err == nil {
+		t.Errorf("Expected an error storing a token in a command")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Split a comma-separated list of token paths, one per workspace.
+func splitTokenPaths(list string) []string {
+	paths := make([]string, 0)
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Connect to the workspace of each token, refusing two tokens for the same
+// one.  Whatever the db stored before it held more than one workspace is
+// given to the first.
+func connectWorkspaces(tokenPaths []string, opts APIOptions, db *SlackBoxDB) ([]*SlackBoxAPI, error) {
+	if len(tokenPaths) == 0 {
+		return nil, errors.New("No token paths given")
+	}
+
+	apis := make([]*SlackBoxAPI, 0, len(tokenPaths))
+	pathsByTeam := make(map[string]string)
+	for _, tokenPath := range tokenPaths {
+		token, err := readToken(tokenPath)
+		if err != nil {
+			return nil, err
+		}
+
+		api, err := ConnectAPI(token, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to slack with %s: %s", tokenPath, err)
+		}
+
+		if other, found := pathsByTeam[api.TeamID()]; found {
+			return nil, fmt.Errorf("%s and %s are both for the workspace %s", other, tokenPath, api.TeamName())
+		}
+		pathsByTeam[api.TeamID()] = tokenPath
+
+		apis = append(apis, api)
+	}
+
+	err := db.ClaimUnowned(apis[0].TeamID())
+	if err != nil {
+		return nil, err
+	}
+
+	return apis, nil
+}
+
+func toSlackAPIs(apis []*SlackBoxAPI) []SlackAPI {
+	slackAPIs := make([]SlackAPI, 0, len(apis))
+	for _, api := range apis {
+		slackAPIs = append(slackAPIs, api)
+	}
+	return slackAPIs
+}
+
+// The api for the workspace with the id given, which errors if it isn't one
+// of those configured rather than reaching slack through another.
+func apiForTeam(apis []SlackAPI, teamID string) (SlackAPI, error) {
+	for _, api := range apis {
+		if api.TeamID() == teamID {
+			return api, nil
+		}
+	}
+	return nil, fmt.Errorf("Workspace %s isn't connected", teamID)
+}